@@ -1,5 +1,7 @@
 package authorizer
 
+import "errors"
+
 // Error structure for the authorizer interface
 type Error struct {
 	Err error
@@ -10,13 +12,14 @@ func (e Error) Error() string {
 	return e.Err.Error()
 }
 
+// Unwrap Returns the underlying error
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
 // IsAuthorizationError Based on the error type it returns true
 // if it is an authorization error and false otherwise
 func IsAuthorizationError(err error) bool {
-	switch err.(type) {
-	case Error:
-		return true
-	default:
-		return false
-	}
+	var e Error
+	return errors.As(err, &e)
 }
@@ -18,6 +18,12 @@ type Operation string
 const (
 	// Uploading specific flag for summary related to uploading process.
 	Uploading Operation = "Uploading"
+	// Processing specific flag for summary related to the downstream processing of an upload.
+	Processing Operation = "Processing"
+	// RemoteConfiguration specific flag for summary related to fetching the remote gathering configuration.
+	RemoteConfiguration Operation = "RemoteConfiguration"
+	// PullingSCACerts specific flag for summary related to pulling the simple content access certificates.
+	PullingSCACerts Operation = "PullingSCACertificates"
 )
 
 // Summary a structure describing the health, time, and count of an operation
@@ -3,9 +3,13 @@ module github.com/redhatinsights/insights-ingress-http-client
 go 1.14
 
 require (
+	github.com/klauspost/compress v1.11.4
 	github.com/openshift/api v0.0.0-20201214114959-164a2fb63b5f
 	github.com/openshift/client-go v0.0.0-00010101000000-000000000000
+	github.com/robertkrimen/otto v0.0.0-20200922221731-ef014fd054ac
 	golang.org/x/net v0.0.0-20201209123823-ac852fbbde11
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
+	gopkg.in/sourcemap.v1 v1.0.5 // indirect
 	k8s.io/api v0.20.0
 	k8s.io/apimachinery v0.20.0
 	k8s.io/client-go v11.0.0+incompatible
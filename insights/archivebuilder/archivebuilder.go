@@ -0,0 +1,239 @@
+package archivebuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+)
+
+const (
+	// fileMode The mode written for every record in the archive
+	fileMode = 0640
+)
+
+// Record Accounting information for a single record written to the archive
+type Record struct {
+	Name string
+	// Size uncompressed size of the record contents
+	Size int64
+	// Compressed number of compressed bytes the record added to the archive
+	Compressed int64
+}
+
+// BudgetError An error for a record that does not fit in the remaining byte budget
+type BudgetError struct {
+	Record   string
+	Written  int64
+	MaxBytes int64
+}
+
+// Error Obtains the error string from the error object
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("record %s exceeds the archive budget: %d of %d bytes written", e.Record, e.Written, e.MaxBytes)
+}
+
+// Unwrap Allows errors.Is to match insightsclient.ErrTooLong
+func (e *BudgetError) Unwrap() error {
+	return insightsclient.ErrTooLong
+}
+
+// Builder Writes named records into a deterministic tar.gz archive
+type Builder struct {
+	maxBytes int64
+	modTime  time.Time
+
+	buf *bytes.Buffer
+	// lw limits the compressed bytes written to buf, nil without a budget
+	lw      *insightsclient.LimitedWriter
+	gw      *gzip.Writer
+	tw      *tar.Writer
+	names   map[string]struct{}
+	records []Record
+	closed  bool
+	err     error
+}
+
+// New Initialize a new archive builder object, every record gets modTime as its mtime
+// and the compressed archive is never allowed to grow past maxBytes
+func New(maxBytes int64, modTime time.Time) *Builder {
+	buf := &bytes.Buffer{}
+	var (
+		out io.Writer = buf
+		lw  *insightsclient.LimitedWriter
+	)
+	if maxBytes > 0 {
+		// the compressed output fails with insightsclient.ErrTooLong as soon as it outgrows the budget
+		lw = &insightsclient.LimitedWriter{W: buf, N: maxBytes}
+		out = lw
+	}
+	// leave the gzip header Name and ModTime empty so equal inputs produce equal output
+	gw := gzip.NewWriter(out)
+	return &Builder{
+		maxBytes: maxBytes,
+		modTime:  modTime.UTC().Truncate(time.Second),
+		buf:      buf,
+		lw:       lw,
+		gw:       gw,
+		tw:       tar.NewWriter(gw),
+		names:    make(map[string]struct{}),
+	}
+}
+
+// NewForClient Initialize a new archive builder object using the budget of the client
+func NewForClient(client *insightsclient.Client, modTime time.Time) *Builder {
+	return New(client.MaxBytes(), modTime)
+}
+
+// AddBytes Adds a record with the given contents
+func (b *Builder) AddBytes(name string, data []byte) error {
+	return b.AddReader(name, bytes.NewReader(data), int64(len(data)))
+}
+
+// AddJSON Adds a record with the JSON encoding of v
+func (b *Builder) AddJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unable to marshal record %s: %v", name, err)
+	}
+	return b.AddBytes(name, data)
+}
+
+// AddReader Adds a record streamed from r, the budget applies to the compressed bytes and the record
+// fails as soon as they outgrow it. The tar format needs the size up front, so when size is negative
+// the contents are buffered uncompressed before being written, the buffering stops once the record
+// would compress to more than the remaining budget.
+func (b *Builder) AddReader(name string, r io.Reader, size int64) error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.closed {
+		return fmt.Errorf("unable to add record %s: archive already closed", name)
+	}
+	if name == "" {
+		return fmt.Errorf("record name must not be empty")
+	}
+	if _, ok := b.names[name]; ok {
+		return fmt.Errorf("duplicate record %s", name)
+	}
+	if size < 0 {
+		data, err := b.readAll(name, r)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+		size = int64(len(data))
+	}
+
+	before := int64(b.buf.Len())
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     fileMode,
+		ModTime:  b.modTime,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return b.fail(b.budgetError(name, fmt.Errorf("unable to write header for record %s: %v", name, err)))
+	}
+	n, err := io.Copy(b.tw, io.LimitReader(r, size))
+	if err != nil {
+		return b.fail(b.budgetError(name, fmt.Errorf("unable to write record %s: %v", name, err)))
+	}
+	if n != size {
+		return b.fail(fmt.Errorf("record %s is shorter than declared: %d of %d bytes", name, n, size))
+	}
+	if extra, _ := r.Read(make([]byte, 1)); extra > 0 {
+		return b.fail(fmt.Errorf("record %s is longer than the declared %d bytes", name, size))
+	}
+	if err := b.tw.Flush(); err != nil {
+		return b.fail(b.budgetError(name, err))
+	}
+	if err := b.gw.Flush(); err != nil {
+		return b.fail(b.budgetError(name, err))
+	}
+
+	written := int64(b.buf.Len())
+	b.names[name] = struct{}{}
+	b.records = append(b.records, Record{Name: name, Size: size, Compressed: written - before})
+	return nil
+}
+
+// readAll buffers a record of unknown size, failing once it would compress to more than the remaining budget
+func (b *Builder) readAll(name string, r io.Reader) ([]byte, error) {
+	if b.lw == nil {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read record %s: %v", name, err)
+		}
+		return data, nil
+	}
+	// the record is compressed on the side as it is read, the archive compresses it again once its size is known
+	var data bytes.Buffer
+	probe := &insightsclient.LimitedWriter{W: ioutil.Discard, N: b.lw.N}
+	pw := gzip.NewWriter(probe)
+	_, err := io.Copy(io.MultiWriter(&data, pw), r)
+	if err == nil {
+		err = pw.Close()
+	}
+	if errors.Is(err, insightsclient.ErrTooLong) {
+		return nil, &BudgetError{Record: name, Written: int64(b.buf.Len()) + b.lw.N - probe.N, MaxBytes: b.maxBytes}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read record %s: %v", name, err)
+	}
+	return data.Bytes(), nil
+}
+
+// budgetError returns a *BudgetError when err comes from the compressed output outgrowing the budget
+func (b *Builder) budgetError(name string, err error) error {
+	if errors.Is(err, insightsclient.ErrTooLong) {
+		return &BudgetError{Record: name, Written: int64(b.buf.Len()), MaxBytes: b.maxBytes}
+	}
+	return err
+}
+
+// Records Returns the accounting information of the records added so far
+func (b *Builder) Records() []Record {
+	records := make([]Record, len(b.records))
+	copy(records, b.records)
+	return records
+}
+
+// Size Returns the number of compressed bytes written so far
+func (b *Builder) Size() int64 {
+	return int64(b.buf.Len())
+}
+
+// Close Finishes the archive and returns it ready to be passed to insightsuploader.Controller.Upload
+func (b *Builder) Close() (io.ReadCloser, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.closed {
+		return nil, fmt.Errorf("archive already closed")
+	}
+	b.closed = true
+	if err := b.tw.Close(); err != nil {
+		return nil, b.fail(b.budgetError("trailer", err))
+	}
+	if err := b.gw.Close(); err != nil {
+		return nil, b.fail(b.budgetError("trailer", err))
+	}
+	return ioutil.NopCloser(bytes.NewReader(b.buf.Bytes())), nil
+}
+
+// fail records the first error, a partially written archive cannot be recovered
+func (b *Builder) fail(err error) error {
+	if b.err == nil {
+		b.err = err
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package archivebuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+)
+
+var modTime = time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+
+func readArchive(t *testing.T, r io.Reader) map[string]string {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("unable to read gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("unable to read tar: %v", err)
+		}
+		if !hdr.ModTime.Equal(modTime.Truncate(time.Second)) {
+			t.Errorf("unexpected mtime %v of %s", hdr.ModTime, hdr.Name)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+}
+
+func build(t *testing.T) []byte {
+	b := New(0, modTime)
+	if err := b.AddBytes("config/id", []byte("cluster")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddJSON("config/version.json", map[string]string{"version": "4.7"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddReader("config/streamed", strings.NewReader("streamed"), -1); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	return data
+}
+
+func TestBuild(t *testing.T) {
+	first := build(t)
+	if second := build(t); !bytes.Equal(first, second) {
+		t.Fatalf("equal records produced different archives")
+	}
+	files := readArchive(t, bytes.NewReader(first))
+	expected := map[string]string{
+		"config/id":           "cluster",
+		"config/version.json": `{"version":"4.7"}`,
+		"config/streamed":     "streamed",
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("unexpected records %v", files)
+	}
+	for name, contents := range expected {
+		if files[name] != contents {
+			t.Errorf("unexpected contents of %s: %q", name, files[name])
+		}
+	}
+}
+
+func TestAddReader(tt *testing.T) {
+	random := make([]byte, 4096)
+	_, _ = rand.Read(random)
+	// compresses to a few hundred bytes, far below its own length
+	zeros := make([]byte, 64*1024)
+	testCases := []struct {
+		Name     string
+		MaxBytes int64
+		Data     []byte
+		Size     int64
+		TooLong  bool
+		Err      string
+	}{
+		{Name: "Declared size", Data: []byte("data"), Size: 4},
+		{Name: "Unknown size", Data: []byte("data"), Size: -1},
+		{Name: "Unknown size without budget", Data: random, Size: -1},
+		{Name: "Unknown size within budget", MaxBytes: 8192, Data: random, Size: -1},
+		{Name: "Unknown size over budget", MaxBytes: 1024, Data: random, Size: -1, TooLong: true},
+		{Name: "Declared size over budget", MaxBytes: 1024, Data: random, Size: int64(len(random)), TooLong: true},
+		{Name: "Compressible declared size within budget", MaxBytes: 1024, Data: zeros, Size: int64(len(zeros))},
+		{Name: "Compressible unknown size within budget", MaxBytes: 1024, Data: zeros, Size: -1},
+		{Name: "Shorter than declared", Data: []byte("data"), Size: 5, Err: "shorter than declared"},
+		{Name: "Longer than declared", Data: []byte("data"), Size: 3, Err: "longer than the declared"},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			b := New(tc.MaxBytes, modTime)
+			err := b.AddReader("record", bytes.NewReader(tc.Data), tc.Size)
+			switch {
+			case tc.TooLong:
+				if !errors.Is(err, insightsclient.ErrTooLong) {
+					t.Fatalf("expected ErrTooLong, got %v", err)
+				}
+				return
+			case tc.Err != "":
+				if err == nil || !strings.Contains(err.Error(), tc.Err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.Err, err)
+				}
+				if _, err := b.Close(); err == nil {
+					t.Fatalf("expected the failed archive not to close")
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error %v", err)
+			}
+			records := b.Records()
+			if len(records) != 1 || records[0].Size != int64(len(tc.Data)) || records[0].Compressed <= 0 {
+				t.Fatalf("unexpected records %v", records)
+			}
+			r, err := b.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if files := readArchive(t, r); files["record"] != string(tc.Data) {
+				t.Fatalf("unexpected archive contents")
+			}
+		})
+	}
+}
+
+func TestAddReaderInvalid(t *testing.T) {
+	b := New(0, modTime)
+	if err := b.AddBytes("", nil); err == nil {
+		t.Errorf("expected an error for an empty name")
+	}
+	if err := b.AddBytes("record", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddBytes("record", nil); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("expected a duplicate record error, got %v", err)
+	}
+	if _, err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddBytes("other", nil); err == nil {
+		t.Errorf("expected an error adding to a closed archive")
+	}
+	if _, err := b.Close(); err == nil {
+		t.Errorf("expected an error closing twice")
+	}
+}
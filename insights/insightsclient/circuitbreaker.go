@@ -0,0 +1,170 @@
+package insightsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// CircuitState The state of a circuit breaker, the values are exported as the state metric
+type CircuitState int
+
+const (
+	// CircuitClosed requests are sent
+	CircuitClosed CircuitState = 0
+	// CircuitHalfOpen a single probe request is sent to find out whether ingress recovered
+	CircuitHalfOpen CircuitState = 1
+	// CircuitOpen requests are skipped until the cool-down has passed
+	CircuitOpen CircuitState = 2
+)
+
+// String Returns the name of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// CircuitOpenError An error for an upload skipped because the circuit breaker is open
+type CircuitOpenError struct {
+	// RetryAt the time the breaker lets a probe through
+	RetryAt time.Time
+	// Failures the number of consecutive failures that opened the breaker
+	Failures int
+}
+
+// Error Obtains the error string from the error object
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("upload skipped after %d consecutive failures, next attempt at %s", e.Failures, e.RetryAt.Format(time.RFC3339))
+}
+
+// CircuitBreaker Stops sending requests after repeated server or transport failures
+type CircuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+	// exposed for tests
+	now func() time.Time
+
+	lock     sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+	onChange func(CircuitState)
+}
+
+// NewCircuitBreaker Initialize a new circuit breaker object that opens after threshold
+// consecutive failures and lets a probe through once coolDown has passed
+func NewCircuitBreaker(threshold int, coolDown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		threshold: threshold,
+		coolDown:  coolDown,
+		now:       time.Now,
+	}
+}
+
+// State Returns the current state of the breaker, a nil breaker is always closed
+func (b *CircuitBreaker) State() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == CircuitOpen && !b.now().Before(b.openedAt.Add(b.coolDown)) {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// Allow Returns a *CircuitOpenError if a request must not be sent now,
+// after the cool-down a single caller is allowed through as a probe
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		retryAt := b.openedAt.Add(b.coolDown)
+		if b.now().Before(retryAt) {
+			return &CircuitOpenError{RetryAt: retryAt, Failures: b.failures}
+		}
+		b.setState(CircuitHalfOpen)
+		b.probing = true
+		return nil
+	case CircuitHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{RetryAt: b.now().Add(b.coolDown), Failures: b.failures}
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record Updates the breaker with the outcome of a request, only a success closes it and only server
+// and transport errors count as failures, any other error says nothing about ingress and leaves the
+// failures as they were, a probe ending that way lets the next caller probe again
+func (b *CircuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+	if !isCircuitFailure(err) {
+		if b.state == CircuitHalfOpen {
+			// the cool-down has already passed, Allow lets the next probe through
+			b.setState(CircuitOpen)
+		}
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(CircuitOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state CircuitState) {
+	if b.state == state {
+		return
+	}
+	klog.V(2).Infof("Circuit breaker changed from %s to %s after %d consecutive failures", b.state, state, b.failures)
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
+
+func isCircuitFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var (
+		serverError    *ServerError
+		transportError *TransportError
+	)
+	return errors.As(err, &serverError) || (errors.As(err, &transportError) && !errors.Is(err, ErrTooLong))
+}
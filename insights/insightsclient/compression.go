@@ -0,0 +1,66 @@
+package insightsclient
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
+)
+
+// LimitedWriter writes to W but stops with ErrTooLong once more than N bytes
+// would have been written. Each call to Write updates N to reflect the new amount remaining.
+type LimitedWriter struct {
+	W io.Writer // underlying writer
+	N int64     // max bytes remaining
+}
+
+func (l *LimitedWriter) Write(p []byte) (n int, err error) {
+	if int64(len(p)) > l.N {
+		n, err = l.W.Write(p[:l.N])
+		l.N -= int64(n)
+		if err == nil {
+			err = ErrTooLong
+		}
+		return n, err
+	}
+	n, err = l.W.Write(p)
+	l.N -= int64(n)
+	return n, err
+}
+
+// NewCompressor Wraps w with the writer of the compression algorithm, zero selects the default level
+func NewCompressor(w io.Writer, compression source.Compression, level int) (io.WriteCloser, error) {
+	switch compression {
+	case source.CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case source.CompressionZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// copyCompressed compresses src into dst, the limit applies to the compressed bytes
+func copyCompressed(dst io.Writer, src io.Reader, compression source.Compression, level int, limit int64) (int64, int64, error) {
+	lw := &LimitedWriter{W: dst, N: limit}
+	cw, err := NewCompressor(lw, compression, level)
+	if err != nil {
+		return 0, limit, err
+	}
+	if _, err := io.Copy(cw, src); err != nil {
+		cw.Close()
+		return limit - lw.N, lw.N, err
+	}
+	err = cw.Close()
+	return limit - lw.N, lw.N, err
+}
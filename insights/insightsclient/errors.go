@@ -0,0 +1,279 @@
+package insightsclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redhatinsights/insights-ingress-http-client/authorizer"
+)
+
+var (
+	// ErrUnauthorized Matched by errors.Is for a 401 response
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden Matched by errors.Is for a 403 response
+	ErrForbidden = errors.New("forbidden")
+	// ErrBadRequest Matched by errors.Is for a 400 response
+	ErrBadRequest = errors.New("bad request")
+	// ErrPayloadTooLarge Matched by errors.Is for a 413 response
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrUnsupportedMediaType Matched by errors.Is for a 415 response
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrRateLimited Matched by errors.Is for a 429 response
+	ErrRateLimited = errors.New("rate limited")
+	// ErrServer Matched by errors.Is for a 5xx response
+	ErrServer = errors.New("server error")
+	// ErrUnexpectedStatus Matched by errors.Is for any other response outside of the 2xx range
+	ErrUnexpectedStatus = errors.New("unexpected status")
+)
+
+// ResponseError The details of an unsuccessful response shared by the typed Send errors
+type ResponseError struct {
+	StatusCode int
+	RequestID  string
+	// Body the response body truncated to responseBodyLogLen bytes
+	Body string
+	URL  string
+}
+
+func newResponseError(resp *http.Response, requestID string) ResponseError {
+	e := ResponseError{
+		StatusCode: resp.StatusCode,
+		RequestID:  requestID,
+		Body:       responseBody(resp),
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		e.URL = resp.Request.URL.String()
+	}
+	return e
+}
+
+// Response Returns the response details, it lets callers handle every response error alike
+func (e ResponseError) Response() ResponseError {
+	return e
+}
+
+// UnauthorizedError An error for a 401 response
+type UnauthorizedError struct {
+	ResponseError
+}
+
+// Error Obtains the error string from the error object
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("your Red Hat account is not enabled for remote support or your token has expired: %s", e.Body)
+}
+
+// Unwrap Keeps the error recognized by authorizer.IsAuthorizationError and matched by ErrUnauthorized
+func (e *UnauthorizedError) Unwrap() error {
+	return authorizer.Error{Err: ErrUnauthorized}
+}
+
+// ForbiddenError An error for a 403 response
+type ForbiddenError struct {
+	ResponseError
+}
+
+// Error Obtains the error string from the error object
+func (e *ForbiddenError) Error() string {
+	return "your Red Hat account is not enabled for remote support"
+}
+
+// Unwrap Keeps the error recognized by authorizer.IsAuthorizationError and matched by ErrForbidden
+func (e *ForbiddenError) Unwrap() error {
+	return authorizer.Error{Err: ErrForbidden}
+}
+
+// BadRequestError An error for a 400 response, usually a malformed payload
+type BadRequestError struct {
+	ResponseError
+}
+
+// Error Obtains the error string from the error object
+func (e *BadRequestError) Error() string {
+	return fmt.Sprintf("gateway server bad request: %s (request=%s): %s", e.URL, e.RequestID, e.Body)
+}
+
+// Is Allows errors.Is to match ErrBadRequest
+func (e *BadRequestError) Is(target error) bool {
+	return target == ErrBadRequest
+}
+
+// PayloadTooLargeError An error for a 413 response
+type PayloadTooLargeError struct {
+	ResponseError
+}
+
+// Error Obtains the error string from the error object
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("gateway server rejected the payload as too large (request=%s): %s", e.RequestID, e.Body)
+}
+
+// Is Allows errors.Is to match ErrPayloadTooLarge
+func (e *PayloadTooLargeError) Is(target error) bool {
+	return target == ErrPayloadTooLarge
+}
+
+// UnsupportedMediaTypeError An error for a 415 response, the payload mime type is not accepted
+type UnsupportedMediaTypeError struct {
+	ResponseError
+}
+
+// Error Obtains the error string from the error object
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("gateway server does not support the payload media type (request=%s): %s", e.RequestID, e.Body)
+}
+
+// Is Allows errors.Is to match ErrUnsupportedMediaType
+func (e *UnsupportedMediaTypeError) Is(target error) bool {
+	return target == ErrUnsupportedMediaType
+}
+
+// RateLimitedError An error for a 429 response
+type RateLimitedError struct {
+	ResponseError
+	// RetryAfter the delay requested by the server, zero when it did not send one
+	RetryAfter time.Duration
+}
+
+// Error Obtains the error string from the error object
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("gateway server rate limited the upload, retry after %s (request=%s): %s", e.RetryAfter, e.RequestID, e.Body)
+	}
+	return fmt.Sprintf("gateway server rate limited the upload (request=%s): %s", e.RequestID, e.Body)
+}
+
+// Is Allows errors.Is to match ErrRateLimited
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// ServerError An error for a 5xx response
+type ServerError struct {
+	ResponseError
+}
+
+// Error Obtains the error string from the error object
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("gateway server reported unexpected error code: %d (request=%s): %s", e.StatusCode, e.RequestID, e.Body)
+}
+
+// Is Allows errors.Is to match ErrServer
+func (e *ServerError) Is(target error) bool {
+	return target == ErrServer
+}
+
+// UnexpectedStatusError An error for any other response outside of the 2xx range
+type UnexpectedStatusError struct {
+	ResponseError
+}
+
+// Error Obtains the error string from the error object
+func (e *UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("gateway server reported unexpected error code: %d (request=%s): %s", e.StatusCode, e.RequestID, e.Body)
+}
+
+// Is Allows errors.Is to match ErrUnexpectedStatus
+func (e *UnexpectedStatusError) Is(target error) bool {
+	return target == ErrUnexpectedStatus
+}
+
+// AuthorizationError An error for a request that was not sent because the authorizer failed
+type AuthorizationError struct {
+	Err error
+}
+
+// Error Obtains the error string from the error object
+func (e *AuthorizationError) Error() string {
+	return fmt.Sprintf("unable to authorize the request: %v", e.Err)
+}
+
+// Unwrap Returns the authorizer error, so the failure is reported as an authorization error
+func (e *AuthorizationError) Unwrap() error {
+	return authorizer.Error{Err: e.Err}
+}
+
+// TransportError An error for a request that never got a response
+type TransportError struct {
+	Err error
+	// Proxy the proxy decision for the request, empty when the proxy control does not explain it
+	Proxy string
+}
+
+// Error Obtains the error string from the error object
+func (e *TransportError) Error() string {
+	if e.Proxy != "" {
+		return fmt.Sprintf("unable to build request to connect to Insights server: %v (%s)", e.Err, e.Proxy)
+	}
+	return fmt.Sprintf("unable to build request to connect to Insights server: %v", e.Err)
+}
+
+// Unwrap Returns the underlying transport error
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// TooLongError An error for a payload that exceeded the maxBytes limit of the client while being sent
+type TooLongError struct {
+	MaxBytes int64
+}
+
+// Error Obtains the error string from the error object
+func (e *TooLongError) Error() string {
+	return fmt.Sprintf("%v: limit is %d bytes", ErrTooLong, e.MaxBytes)
+}
+
+// Unwrap Allows errors.Is to match ErrTooLong
+func (e *TooLongError) Unwrap() error {
+	return ErrTooLong
+}
+
+// responseError maps an unsuccessful response to its typed error
+func responseError(resp *http.Response, requestID string) error {
+	re := newResponseError(resp, requestID)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return &UnauthorizedError{re}
+	case resp.StatusCode == http.StatusForbidden:
+		return &ForbiddenError{re}
+	case resp.StatusCode == http.StatusBadRequest:
+		return &BadRequestError{re}
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return &PayloadTooLargeError{re}
+	case resp.StatusCode == http.StatusUnsupportedMediaType:
+		return &UnsupportedMediaTypeError{re}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitedError{ResponseError: re, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode >= 500:
+		return &ServerError{re}
+	default:
+		return &UnexpectedStatusError{re}
+	}
+}
+
+// retryAfter parses the Retry-After header in either its seconds or HTTP date form
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// StatusCode Returns the HTTP status code carried by a Send error, 0 if there was no response
+func StatusCode(err error) int {
+	var re interface{ Response() ResponseError }
+	if errors.As(err, &re) {
+		return re.Response().StatusCode
+	}
+	return 0
+}
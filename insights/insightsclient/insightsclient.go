@@ -13,19 +13,25 @@ import (
 	"net"
 	"net/http"
 	"net/textproto"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/transport"
 	"k8s.io/component-base/metrics"
 	"k8s.io/component-base/metrics/legacyregistry"
 
 	"k8s.io/klog"
 
-	"github.com/redhatinsights/insights-ingress-http-client/authorizer"
 	"github.com/redhatinsights/insights-ingress-http-client/insights/proxycontrol"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/ratelimit"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
 	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestsigner"
 	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
 )
 
@@ -41,6 +47,10 @@ type Client struct {
 	metricsName  string
 	proxyCtrl    *proxycontrol.ProxyControl
 	reqDecorator *requestdecorator.RequestDecorator
+	downloads    downloadCache
+	limiter      *ratelimit.Limiter
+	breaker      *CircuitBreaker
+	signer       *requestsigner.Signer
 }
 
 // ErrWaitingForVersion An error due to cluster version responding slowly
@@ -71,6 +81,43 @@ func New(client *http.Client, maxBytes int64, certPath string, metricsName strin
 	}
 }
 
+// SetLimiter Sets the limiter throttling the upload bandwidth and request rate, the same
+// limiter can be set on several clients to share the limits between them
+func (c *Client) SetLimiter(limiter *ratelimit.Limiter) {
+	c.limiter = limiter
+}
+
+// SetSigner Sets the signer adding the digest, and optionally an HMAC signature, of the body to uploads
+func (c *Client) SetSigner(signer *requestsigner.Signer) {
+	c.signer = signer
+}
+
+// SetCircuitBreaker Sets the circuit breaker that skips uploads while ingress keeps failing, nil removes it
+func (c *Client) SetCircuitBreaker(breaker *CircuitBreaker) {
+	if breaker == nil {
+		gaugeCircuitBreakerState.WithLabelValues(c.metricsName).Set(float64(CircuitClosed))
+		c.breaker = nil
+		return
+	}
+	breaker.lock.Lock()
+	breaker.onChange = func(state CircuitState) {
+		gaugeCircuitBreakerState.WithLabelValues(c.metricsName).Set(float64(state))
+	}
+	breaker.lock.Unlock()
+	gaugeCircuitBreakerState.WithLabelValues(c.metricsName).Set(float64(breaker.State()))
+	c.breaker = breaker
+}
+
+// CircuitBreakerState Returns the state of the circuit breaker, closed when none is set
+func (c *Client) CircuitBreakerState() CircuitState {
+	return c.breaker.State()
+}
+
+// MaxBytes Returns the maximum number of payload bytes the client will upload
+func (c *Client) MaxBytes() int64 {
+	return c.maxBytes
+}
+
 func (c *Client) getTrustedCABundle() (*x509.CertPool, error) {
 	caBytes, err := ioutil.ReadFile(c.certPath)
 	if err != nil {
@@ -92,32 +139,106 @@ func (c *Client) getTrustedCABundle() (*x509.CertPool, error) {
 // clientTransport creates new http.Transport with either system or configured Proxy
 func (c *Client) clientTransport() http.RoundTripper {
 	prxy := *(c.proxyCtrl)
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	proxy := prxy.NewSystemOrConfiguredProxy()
+	if tracer, ok := prxy.(proxycontrol.ProxyTracer); ok && bool(klog.V(4)) {
+		proxyFunc := proxy
+		proxy = func(req *http.Request) (*url.URL, error) {
+			if decision, err := tracer.TraceProxy(req); err == nil {
+				klog.Infof("Proxy for %s: %s", req.URL.Host, decision)
+			}
+			return proxyFunc(req)
+		}
+	}
 	clientTransport := &http.Transport{
-		Proxy: prxy.NewSystemOrConfiguredProxy(),
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		Proxy:               proxy,
+		DialContext:         dialer.DialContext,
 		TLSHandshakeTimeout: 10 * time.Second,
 		DisableKeepAlives:   true,
 	}
-
+	if proxyDialer, ok := prxy.(proxycontrol.ProxyDialer); ok {
+		clientTransport.DialContext = proxyDialer.NewProxyDialer(dialer)
+	}
 	// get the cluster proxy trusted CA bundle in case the proxy need it
-	rootCAs, err := c.getTrustedCABundle()
-	if err != nil {
-		klog.Errorf("Failed to get proxy trusted CA: %v", err)
+	var rootCAs *x509.CertPool
+	if caProvider, ok := prxy.(proxycontrol.TrustedCAProvider); ok {
+		rootCAs = caProvider.TrustedCABundle()
+	}
+	if rootCAs == nil {
+		var err error
+		rootCAs, err = c.getTrustedCABundle()
+		if err != nil {
+			klog.Errorf("Failed to get proxy trusted CA: %v", err)
+		}
 	}
 	if rootCAs != nil {
 		clientTransport.TLSClientConfig = &tls.Config{}
 		clientTransport.TLSClientConfig.RootCAs = rootCAs
 	}
 
+	if headerProvider, ok := prxy.(proxycontrol.ProxyConnectHeaderProvider); ok {
+		return transport.DebugWrappers(&connectHeaderTransport{base: clientTransport, provider: headerProvider})
+	}
 	return transport.DebugWrappers(clientTransport)
 }
 
+// connectHeaderTransport sends the CONNECT headers of the proxy control only with the requests they belong to,
+// the headers of a transport apply to every proxy it connects to, the fallback proxies included
+type connectHeaderTransport struct {
+	base     *http.Transport
+	provider proxycontrol.ProxyConnectHeaderProvider
+}
+
+func (t *connectHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header := t.provider.ProxyConnectHeader(req)
+	if header == nil {
+		return t.base.RoundTrip(req)
+	}
+	// keep-alives are disabled, a transport for the request does not give up reusing connections
+	rt := t.base.Clone()
+	rt.ProxyConnectHeader = header
+	return rt.RoundTrip(req)
+}
+
+// TraceProxy Explains the proxy used to reach the endpoint, it fails if the proxy control can not explain its choice
+func (c *Client) TraceProxy(endpoint string) (proxycontrol.ProxyDecision, error) {
+	tracer, ok := (*c.proxyCtrl).(proxycontrol.ProxyTracer)
+	if !ok {
+		return proxycontrol.ProxyDecision{}, fmt.Errorf("the proxy control does not explain its choice of proxy")
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return proxycontrol.ProxyDecision{}, err
+	}
+	return tracer.TraceProxy(req)
+}
+
+// transportError wraps the error of a request that never got a response, with the proxy decision when it is known
+func (c *Client) transportError(req *http.Request, err error) *TransportError {
+	transportErr := &TransportError{Err: err}
+	if tracer, ok := (*c.proxyCtrl).(proxycontrol.ProxyTracer); ok {
+		if decision, err := tracer.TraceProxy(req); err == nil {
+			transportErr.Proxy = decision.String()
+		}
+	}
+	return transportErr
+}
+
 // SetupRequest creates a new request, adds headers to request object for communication, and returns the request
 func (c *Client) SetupRequest(ctx context.Context, method, uri string, body *bytes.Buffer, contentType string) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	// the authorizer of the request is recorded, so a rejected token is dropped from the authorizer that issued it
+	if _, ok := requestauthorizer.RecordedAuthorizer(ctx); !ok {
+		ctx, _ = requestauthorizer.WithAuthorizerRecord(ctx)
+	}
+	// a nil *bytes.Buffer must not reach http.NewRequestWithContext as a non-nil io.Reader
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = body
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("could not create request: %v", err)
 	}
@@ -125,7 +246,12 @@ func (c *Client) SetupRequest(ctx context.Context, method, uri string, body *byt
 	if req.Header == nil {
 		req.Header = make(http.Header)
 	}
-	c.reqDecorator.UpdateHeaders(req, contentType)
+	if err := c.reqDecorator.Decorate(ctx, req, contentType); err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		return nil, &AuthorizationError{Err: err}
+	}
 
 	// dynamically set the proxy environment
 	c.client.Transport = c.clientTransport()
@@ -133,51 +259,203 @@ func (c *Client) SetupRequest(ctx context.Context, method, uri string, body *byt
 	return req, nil
 }
 
-// GetMultiPartBodyAndHeaders Get multi-part body and headers for upload
+// do sends the request, a request rejected as unauthorized is sent once more with a new token when the
+// authorizer caches tokens and the body can be replayed, a streamed upload only drops the rejected token
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	var replay func(retry *http.Request) error
+	switch {
+	case req.GetBody != nil:
+		replay = func(retry *http.Request) error {
+			body, err := req.GetBody()
+			retry.Body = body
+			return err
+		}
+	case req.Body == nil || req.Body == http.NoBody:
+		replay = func(*http.Request) error { return nil }
+	}
+	return c.doReplay(req, replay)
+}
+
+// doReplay sends the request like do, replay sets the body of the retry, a nil replay means the body
+// can not be sent again
+func (c *Client) doReplay(req *http.Request, replay func(retry *http.Request) error) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !c.reqDecorator.InvalidateRequestToken(req) {
+		return resp, err
+	}
+	if replay == nil {
+		return resp, nil
+	}
+	retry := req.Clone(req.Context())
+	if err := replay(retry); err != nil {
+		klog.V(2).Infof("Unable to replay the body of %s %s: %v", req.Method, req.URL.String(), err)
+		return resp, nil
+	}
+	retry.Header.Del("Authorization")
+	if err := c.reqDecorator.Decorate(retry.Context(), retry, ""); err != nil {
+		klog.V(2).Infof("Unable to authorize the retry of %s %s: %v", req.Method, req.URL.String(), err)
+		return resp, nil
+	}
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		klog.Warningf("Failed to read response body: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		klog.Warningf("Failed to close response body: %v", err)
+	}
+	klog.V(2).Infof("Retrying %s %s with a new token", req.Method, req.URL.String())
+	return c.client.Do(retry)
+}
+
+// GetMultiPartBodyAndHeaders Get multi-part body and headers for upload. The returned byte count is always 0,
+// the body is streamed while the request is sent so the count is not known before it has been consumed, it is
+// kept for compatibility.
 func (c *Client) GetMultiPartBodyAndHeaders(req *http.Request, data source.Source) int64 {
-	// set the content and content type
+	c.multiPartBody(req, []source.Part{data.FilePart()})
+	return 0
+}
+
+// GetMultiPartBodyAndHeadersForParts Get multi-part body and headers for an upload of several parts,
+// the parts are streamed in order and share the maxBytes limit of the client
+func (c *Client) GetMultiPartBodyAndHeadersForParts(req *http.Request, parts []source.Part) {
+	c.multiPartBody(req, parts)
+}
+
+// multiPartBody sets the request body to a pipe fed by a goroutine, the returned counter
+// holds the number of part bytes written once the body has been consumed, the returned
+// channel is closed once the goroutine stopped reading the parts
+func (c *Client) multiPartBody(req *http.Request, parts []source.Part) (*int64, <-chan struct{}) {
 	var bytesRead int64
+	done := make(chan struct{})
 	pr, pw := io.Pipe()
 	mw := multipart.NewWriter(pw)
-	filename := "payload.tar.gz"
-	if data.Filename != "" {
-		filename = data.Filename
-	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
 	go func() {
-		h := make(textproto.MIMEHeader)
-		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, "file", filename))
-		h.Set("Content-Type", data.Type)
-		fw, err := mw.CreatePart(h)
-		if err != nil {
-			pw.CloseWithError(err)
-			return
-		}
-		r := &LimitedReader{R: data.Contents, N: c.maxBytes}
-		n, err := io.Copy(fw, r)
-		bytesRead = n
-		if err != nil {
-			pw.CloseWithError(err)
+		defer close(done)
+		// the limit is shared by all parts and counts the bytes as sent, after compression
+		remaining := c.maxBytes
+		for _, part := range parts {
+			h := make(textproto.MIMEHeader)
+			if part.Filename != "" {
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, part.Name, part.Filename))
+			} else {
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q`, part.Name))
+			}
+			if part.Type != "" {
+				h.Set("Content-Type", part.Type)
+			}
+			fw, err := mw.CreatePart(h)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if part.Contents == nil {
+				continue
+			}
+			var n int64
+			if part.Compression != source.CompressionNone {
+				n, remaining, err = copyCompressed(fw, part.Contents, part.Compression, part.CompressionLevel, remaining)
+			} else {
+				r := &LimitedReader{R: part.Contents, N: remaining}
+				n, err = io.Copy(fw, r)
+				remaining = r.N
+			}
+			atomic.AddInt64(&bytesRead, n)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
 		}
 		pw.CloseWithError(mw.Close())
 	}()
-	req.Body = pr
-	return bytesRead
+	req.Body = c.limiter.ReadCloser(req.Context(), pr)
+	return &bytesRead, done
+}
+
+// replayParts returns a function rewinding the contents of the parts to where they are now,
+// or nil when the contents of a part can not be read again
+func replayParts(parts []source.Part) func() error {
+	offsets := make([]int64, len(parts))
+	for i, part := range parts {
+		if part.Contents == nil {
+			continue
+		}
+		seeker, ok := part.Contents.(io.Seeker)
+		if !ok {
+			return nil
+		}
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil
+		}
+		offsets[i] = offset
+	}
+	return func() error {
+		for i, part := range parts {
+			if part.Contents == nil {
+				continue
+			}
+			if _, err := part.Contents.(io.Seeker).Seek(offsets[i], io.SeekStart); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Send Posts source data to an endpoint and returns the acknowledgement of the upload
+func (c *Client) Send(ctx context.Context, endpoint string, data source.Source) (*SendResult, error) {
+	return c.SendParts(ctx, endpoint, data.ID, []source.Part{data.FilePart()})
 }
 
-// Send Posts source data to an endpoint
-func (c *Client) Send(ctx context.Context, endpoint string, data source.Source) error {
-	req, err := c.SetupRequest(ctx, "POST", endpoint, nil, data.Type)
+// SendParts Posts several form fields and files in a single multipart request to an endpoint
+func (c *Client) SendParts(ctx context.Context, endpoint string, id string, parts []source.Part) (*SendResult, error) {
+	if err := c.breaker.Allow(); err != nil {
+		klog.V(4).Infof("Not uploading: %v", err)
+		return nil, err
+	}
+	result, err := c.sendParts(ctx, endpoint, id, parts)
+	c.breaker.Record(err)
+	return result, err
+}
+
+func (c *Client) sendParts(ctx context.Context, endpoint string, id string, parts []source.Part) (*SendResult, error) {
+	ctx, authorizerName := requestauthorizer.WithAuthorizerRecord(ctx)
+	req, err := c.SetupRequest(ctx, "POST", endpoint, nil, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	bytesRead := c.GetMultiPartBodyAndHeaders(req, data)
-	klog.V(4).Infof("Uploading %s to %s", data.Type, req.URL.String())
-	resp, err := c.client.Do(req)
+	if err := c.limiter.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	rewind := replayParts(parts)
+	bytesRead, done := c.multiPartBody(req, parts)
+	if err := c.signer.Sign(req); err != nil {
+		return nil, err
+	}
+	// parts that can be read again are streamed once more after a token was rejected
+	var replay func(retry *http.Request) error
+	if rewind != nil {
+		replay = func(retry *http.Request) error {
+			// the rejected request may not have been read to its end, stop streaming it first
+			_ = req.Body.Close()
+			<-done
+			if err := rewind(); err != nil {
+				return err
+			}
+			bytesRead, done = c.multiPartBody(retry, parts)
+			return c.signer.Sign(retry)
+		}
+	}
+	klog.V(4).Infof("Uploading %s to %s", partNames(parts), req.URL.String())
+	resp, err := c.doReplay(req, replay)
 	if err != nil {
 		klog.V(4).Infof("Unable to build a request, possible invalid token: %v", err)
 		// if the request is not build, for example because of invalid endpoint,(maybe some problem with DNS), we want to have record about it in metrics as well.
-		counterRequestSend.WithLabelValues(c.metricsName, "0").Inc()
-		return fmt.Errorf("unable to build request to connect to Insights server: %v", err)
+		counterRequestSend.WithLabelValues(c.metricsName, "0", authorizerName()).Inc()
+		if errors.Is(err, ErrTooLong) {
+			return nil, &TooLongError{MaxBytes: c.maxBytes}
+		}
+		return nil, c.transportError(req, err)
 	}
 
 	requestID := resp.Header.Get("x-rh-insights-request-id")
@@ -191,31 +469,35 @@ func (c *Client) Send(ctx context.Context, endpoint string, data source.Source)
 		}
 	}()
 
-	counterRequestSend.WithLabelValues(c.metricsName, strconv.Itoa(resp.StatusCode)).Inc()
+	counterRequestSend.WithLabelValues(c.metricsName, strconv.Itoa(resp.StatusCode), authorizerName()).Inc()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		klog.V(2).Infof("gateway server %s returned 401, x-rh-insights-request-id=%s", resp.Request.URL, requestID)
-		return authorizer.Error{Err: fmt.Errorf("your Red Hat account is not enabled for remote support or your token has expired: %s", responseBody(resp))}
+	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+		klog.V(2).Infof("gateway server %s returned %d, x-rh-insights-request-id=%s", resp.Request.URL, resp.StatusCode, requestID)
+		return nil, responseError(resp, requestID)
 	}
 
-	if resp.StatusCode == http.StatusForbidden {
-		klog.V(2).Infof("gateway server %s returned 403, x-rh-insights-request-id=%s", resp.Request.URL, requestID)
-		return authorizer.Error{Err: fmt.Errorf("your Red Hat account is not enabled for remote support")}
+	result, err := decodeSendResult(resp, requestID)
+	if err != nil {
+		klog.Warningf("Unable to read the upload acknowledgement: %v", err)
 	}
-
-	if resp.StatusCode == http.StatusBadRequest {
-		return fmt.Errorf("gateway server bad request: %s (request=%s): %s", resp.Request.URL, requestID, responseBody(resp))
+	result.Authorizer = authorizerName()
+	if len(result.RequestID) > 0 {
+		klog.V(2).Infof("Successfully reported id=%s x-rh-insights-request-id=%s, wrote=%d", id, result.RequestID, atomic.LoadInt64(bytesRead))
 	}
 
-	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
-		return fmt.Errorf("gateway server reported unexpected error code: %d (request=%s): %s", resp.StatusCode, requestID, responseBody(resp))
-	}
+	return result, nil
+}
 
-	if len(requestID) > 0 {
-		klog.V(2).Infof("Successfully reported id=%s x-rh-insights-request-id=%s, wrote=%d", data.ID, requestID, bytesRead)
+func partNames(parts []source.Part) string {
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "" {
+			names = append(names, fmt.Sprintf("%s (%s)", part.Name, part.Type))
+		} else {
+			names = append(names, part.Name)
+		}
 	}
-
-	return nil
+	return strings.Join(names, ", ")
 }
 
 func responseBody(r *http.Response) string {
@@ -232,17 +514,23 @@ func responseBody(r *http.Response) string {
 var (
 	counterRequestSend = metrics.NewCounterVec(&metrics.CounterOpts{
 		Help: "Counter of the number of uploads sent",
-	}, []string{"client", "status_code"})
+	}, []string{"client", "status_code", "authorizer"})
+	gaugeCircuitBreakerState = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Help: "State of the upload circuit breaker, 0 closed, 1 half-open, 2 open",
+	}, []string{"client"})
 )
 
 func registerSendMetric(metricName string) error {
 	counterRequestSend.Name = fmt.Sprintf("%s_request_send_total", metricName)
+	gaugeCircuitBreakerState.Name = fmt.Sprintf("%s_circuit_breaker_state", metricName)
 
-	err := legacyregistry.Register(
-		counterRequestSend,
-	)
-	if err != nil {
-		return err
+	// the gauge is registered even when the counter is not, so neither hides the other
+	var errs []error
+	if err := legacyregistry.Register(counterRequestSend); err != nil {
+		errs = append(errs, err)
+	}
+	if err := legacyregistry.Register(gaugeCircuitBreakerState); err != nil {
+		errs = append(errs, err)
 	}
-	return nil
+	return utilerrors.NewAggregate(errs)
 }
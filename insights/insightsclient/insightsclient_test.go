@@ -0,0 +1,856 @@
+package insightsclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/redhatinsights/insights-ingress-http-client/authorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/proxycontrol"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/bearertokenauthorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/chainauthorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/clientcredentialsauthorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/oauth2token"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestsigner"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
+)
+
+func newTestClient(maxBytes int64) *Client {
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	return New(nil, maxBytes, "", "insightsclient_test", &proxyCtrl, requestdecorator.New(nil, nil))
+}
+
+func TestSendErrors(tt *testing.T) {
+	testCases := []struct {
+		Name       string
+		StatusCode int
+		Check      func(err error) bool
+		// Sentinel is matched by errors.Is
+		Sentinel error
+	}{
+		{
+			Name:       "401 is an authorization error",
+			StatusCode: http.StatusUnauthorized,
+			Sentinel:   ErrUnauthorized,
+			Check: func(err error) bool {
+				var e *UnauthorizedError
+				return errors.As(err, &e) && authorizer.IsAuthorizationError(err)
+			},
+		},
+		{
+			Name:       "403 is an authorization error",
+			StatusCode: http.StatusForbidden,
+			Sentinel:   ErrForbidden,
+			Check: func(err error) bool {
+				var e *ForbiddenError
+				return errors.As(err, &e) && authorizer.IsAuthorizationError(err)
+			},
+		},
+		{
+			Name:       "400 is a bad request",
+			StatusCode: http.StatusBadRequest,
+			Sentinel:   ErrBadRequest,
+			Check: func(err error) bool {
+				var e *BadRequestError
+				return errors.As(err, &e) && !authorizer.IsAuthorizationError(err)
+			},
+		},
+		{
+			Name:       "413 is a payload too large",
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Sentinel:   ErrPayloadTooLarge,
+			Check: func(err error) bool {
+				var e *PayloadTooLargeError
+				return errors.As(err, &e)
+			},
+		},
+		{
+			Name:       "415 is an unsupported media type",
+			StatusCode: http.StatusUnsupportedMediaType,
+			Sentinel:   ErrUnsupportedMediaType,
+			Check: func(err error) bool {
+				var e *UnsupportedMediaTypeError
+				return errors.As(err, &e)
+			},
+		},
+		{
+			Name:       "429 is rate limited with retry after",
+			StatusCode: http.StatusTooManyRequests,
+			Sentinel:   ErrRateLimited,
+			Check: func(err error) bool {
+				var e *RateLimitedError
+				return errors.As(err, &e) && e.RetryAfter.Seconds() == 30
+			},
+		},
+		{
+			Name:       "503 is a server error",
+			StatusCode: http.StatusServiceUnavailable,
+			Sentinel:   ErrServer,
+			Check: func(err error) bool {
+				var e *ServerError
+				return errors.As(err, &e)
+			},
+		},
+		{
+			Name:       "418 is an unexpected status",
+			StatusCode: http.StatusTeapot,
+			Sentinel:   ErrUnexpectedStatus,
+			Check: func(err error) bool {
+				var e *UnexpectedStatusError
+				return errors.As(err, &e) && !errors.Is(err, ErrServer)
+			},
+		},
+		{
+			Name:       "202 is a success",
+			StatusCode: http.StatusAccepted,
+			Check: func(err error) bool {
+				return err == nil
+			},
+		},
+	}
+	c := newTestClient(0)
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+				w.Header().Set("x-rh-insights-request-id", "request-1")
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(tc.StatusCode)
+				_, _ = w.Write([]byte("response body"))
+			}))
+			defer srv.Close()
+
+			_, err := c.Send(context.Background(), srv.URL, source.Source{
+				Type:     "application/vnd.redhat.openshift.periodic+tgz",
+				Contents: bytes.NewReader([]byte("payload")),
+			})
+			if !tc.Check(err) {
+				t.Fatalf("unexpected error %T: %v", err, err)
+			}
+			if tc.Sentinel != nil && !errors.Is(err, tc.Sentinel) {
+				t.Fatalf("expected %v to match %v", err, tc.Sentinel)
+			}
+			if err != nil && StatusCode(err) != tc.StatusCode {
+				t.Fatalf("expected status code %d, got %d", tc.StatusCode, StatusCode(err))
+			}
+		})
+	}
+}
+
+func TestSendTooLong(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(16)
+	_, err := c.Send(context.Background(), srv.URL, source.Source{
+		Type:     "application/vnd.redhat.openshift.periodic+tgz",
+		Contents: bytes.NewReader(make([]byte, 1024)),
+	})
+	var e *TooLongError
+	if !errors.As(err, &e) || !errors.Is(err, ErrTooLong) {
+		t.Fatalf("expected a too long error, got %T: %v", err, err)
+	}
+}
+
+func TestSendAtLimit(tt *testing.T) {
+	payload := make([]byte, 1024)
+	testCases := []struct {
+		Name     string
+		MaxBytes int64
+		TooLong  bool
+	}{
+		{Name: "Exactly the limit", MaxBytes: int64(len(payload))},
+		{Name: "One byte over the limit", MaxBytes: int64(len(payload)) - 1, TooLong: true},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer srv.Close()
+
+			c := newTestClient(tc.MaxBytes)
+			_, err := c.Send(context.Background(), srv.URL, source.Source{
+				Type:     "application/vnd.redhat.openshift.periodic+tgz",
+				Contents: bytes.NewReader(payload),
+			})
+			if tc.TooLong != errors.Is(err, ErrTooLong) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tc.TooLong && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestSendResult(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("x-rh-insights-request-id", "header-id")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"request_id":"body-id","upload":{"account_number":"000001","org_id":"123"}}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(0)
+	result, err := c.Send(context.Background(), srv.URL, source.Source{
+		Type:     "application/vnd.redhat.openshift.periodic+tgz",
+		Contents: bytes.NewReader([]byte("payload")),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if result.RequestID != "body-id" || result.Upload.AccountNumber != "000001" || result.Upload.OrgID != "123" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status code %d, got %d", http.StatusAccepted, result.StatusCode)
+	}
+}
+
+func TestWaitForPayload(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/payloads/request-1" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		polls++
+		switch polls {
+		case 1:
+			w.WriteHeader(http.StatusNotFound)
+		case 2:
+			_, _ = w.Write([]byte(`{"data":[{"service":"ingress","status":"received"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"data":[{"service":"ingress","status":"received"},` +
+				`{"service":"ccx-data-pipeline","status":"error","status_msg":"bad archive"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestClient(0)
+	status, err := c.WaitForPayload(context.Background(), srv.URL+"/payloads", "request-1", PollOptions{
+		Interval: time.Millisecond,
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if status.State != PayloadError || status.Message != "bad archive" || polls != 3 {
+		t.Fatalf("unexpected status %+v after %d polls", status, polls)
+	}
+}
+
+func TestWaitForPayloadErrors(tt *testing.T) {
+	testCases := []struct {
+		Status int
+		Polls  int
+	}{
+		{Status: http.StatusBadRequest, Polls: 1},
+		{Status: http.StatusUnauthorized, Polls: 1},
+		{Status: http.StatusForbidden, Polls: 1},
+		{Status: http.StatusTooManyRequests, Polls: 3},
+		{Status: http.StatusServiceUnavailable, Polls: 3},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(strconv.Itoa(tc.Status), func(t *testing.T) {
+			polls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				polls++
+				if polls < 3 {
+					w.WriteHeader(tc.Status)
+					return
+				}
+				_, _ = w.Write([]byte(`{"data":[{"service":"ingress","status":"success"}]}`))
+			}))
+			defer srv.Close()
+
+			c := newTestClient(0)
+			status, err := c.WaitForPayload(context.Background(), srv.URL+"/payloads", "request-1", PollOptions{
+				Interval: time.Millisecond,
+				Timeout:  5 * time.Second,
+			})
+			if polls != tc.Polls {
+				t.Fatalf("expected %d polls, got %d", tc.Polls, polls)
+			}
+			if tc.Polls == 1 && (StatusCode(err) != tc.Status || status.State != PayloadUnknown) {
+				t.Fatalf("expected the status error, got %v", err)
+			}
+			if tc.Polls > 1 && (err != nil || status.State != PayloadSuccess) {
+				t.Fatalf("unexpected status %+v: %v", status, err)
+			}
+		})
+	}
+}
+
+func TestGetReportETag(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(`{"report":{"meta":{"count":2},"data":[{"rule_id":"a","total_risk":4},{"rule_id":"b","total_risk":2}]},"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(0)
+	for i := 0; i < 2; i++ {
+		report, err := c.GetReport(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+		counts := report.CountBySeverity()
+		if report.Meta.Count != 2 || counts[SeverityCritical] != 1 || counts[SeverityModerate] != 1 || report.ETag != `"v1"` {
+			t.Fatalf("unexpected report %+v", report)
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestDownloadAtLimit(tt *testing.T) {
+	body := bytes.Repeat([]byte("a"), 1024)
+	testCases := []struct {
+		Name     string
+		MaxBytes int64
+		TooLong  bool
+	}{
+		{Name: "Exactly the limit", MaxBytes: int64(len(body))},
+		{Name: "One byte over the limit", MaxBytes: int64(len(body)) - 1, TooLong: true},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(body)
+			}))
+			defer srv.Close()
+
+			c := newTestClient(tc.MaxBytes)
+			data, _, err := c.Download(context.Background(), srv.URL)
+			if tc.TooLong {
+				var e *TooLongError
+				if !errors.As(err, &e) || !errors.Is(err, ErrTooLong) {
+					t.Fatalf("expected a too long error, got %v", err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(data, body) {
+				t.Fatalf("unexpected body of %d bytes: %v", len(data), err)
+			}
+		})
+	}
+}
+
+func TestSendParts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected err %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var got []string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			body, _ := ioutil.ReadAll(p)
+			got = append(got, p.FormName()+"|"+p.FileName()+"|"+p.Header.Get("Content-Type")+"|"+string(body))
+		}
+		expected := []string{
+			"file|payload.tar.gz|application/vnd.redhat.openshift.periodic+tgz|archive",
+			"metadata||application/json|{}",
+		}
+		if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+			t.Errorf("unexpected parts %q", got)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(0)
+	_, err := c.SendParts(context.Background(), srv.URL, "id", []source.Part{
+		{Name: "file", Filename: "payload.tar.gz", Type: "application/vnd.redhat.openshift.periodic+tgz", Contents: bytes.NewReader([]byte("archive"))},
+		{Name: "metadata", Type: "application/json", Contents: bytes.NewReader([]byte("{}"))},
+	})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+}
+
+func TestSendCompressed(tt *testing.T) {
+	raw := bytes.Repeat([]byte("uncompressed tar contents "), 4096)
+	testCases := []struct {
+		Name        string
+		Compression source.Compression
+		Decompress  func(r io.Reader) (io.Reader, error)
+	}{
+		{
+			Name:        "gzip",
+			Compression: source.CompressionGzip,
+			Decompress: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			Name:        "zstd",
+			Compression: source.CompressionZstd,
+			Decompress: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, _, err := r.FormFile("file")
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				dr, err := tc.Decompress(f)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				body, err := ioutil.ReadAll(dr)
+				if err != nil || !bytes.Equal(body, raw) {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			defer srv.Close()
+
+			// the raw data is larger than the limit, only the compressed size counts
+			c := newTestClient(int64(len(raw) / 4))
+			_, err := c.Send(context.Background(), srv.URL, source.Source{
+				Type:        "application/vnd.redhat.openshift.periodic+tgz",
+				Contents:    bytes.NewReader(raw),
+				Compression: tc.Compression,
+			})
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+
+			c = newTestClient(16)
+			_, err = c.Send(context.Background(), srv.URL, source.Source{
+				Type:        "application/vnd.redhat.openshift.periodic+tgz",
+				Contents:    bytes.NewReader(raw),
+				Compression: tc.Compression,
+			})
+			if !errors.Is(err, ErrTooLong) {
+				t.Fatalf("expected a too long error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+	c := newTestClient(0)
+	c.SetCircuitBreaker(breaker)
+	send := func() error {
+		_, err := c.Send(context.Background(), srv.URL, source.Source{
+			Type:     "application/vnd.redhat.openshift.periodic+tgz",
+			Contents: bytes.NewReader([]byte("payload")),
+		})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		var e *ServerError
+		if err := send(); !errors.As(err, &e) {
+			t.Fatalf("expected a server error, got %v", err)
+		}
+	}
+	var open *CircuitOpenError
+	if err := send(); !errors.As(err, &open) || requests != 2 || c.CircuitBreakerState() != CircuitOpen {
+		t.Fatalf("expected the breaker to be open, got %v after %d requests", err, requests)
+	}
+
+	// a failed probe after the cool-down opens the breaker again
+	now = now.Add(time.Minute)
+	if c.CircuitBreakerState() != CircuitHalfOpen {
+		t.Fatalf("expected the breaker to be half-open, got %s", c.CircuitBreakerState())
+	}
+	if err := send(); errors.As(err, &open) || requests != 3 {
+		t.Fatalf("expected a probe request, got %v after %d requests", err, requests)
+	}
+	if err := send(); !errors.As(err, &open) {
+		t.Fatalf("expected the breaker to be open, got %v", err)
+	}
+
+	// a successful probe closes it
+	now = now.Add(time.Minute)
+	status = http.StatusAccepted
+	if err := send(); err != nil || c.CircuitBreakerState() != CircuitClosed {
+		t.Fatalf("expected the breaker to be closed, got %v", err)
+	}
+}
+
+func TestCircuitBreakerNeutralErrors(tt *testing.T) {
+	testCases := []struct {
+		Name string
+		Err  error
+	}{
+		{Name: "Canceled", Err: context.Canceled},
+		{Name: "Deadline exceeded", Err: &TransportError{Err: context.DeadlineExceeded}},
+		{Name: "Too long", Err: &TooLongError{MaxBytes: 1}},
+		{Name: "Bad request", Err: &BadRequestError{ResponseError{StatusCode: http.StatusBadRequest}}},
+		{Name: "Rate limited", Err: &RateLimitedError{ResponseError: ResponseError{StatusCode: http.StatusTooManyRequests}}},
+		{Name: "Waiting for version", Err: ErrWaitingForVersion},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			now := time.Now()
+			breaker := NewCircuitBreaker(2, time.Minute)
+			breaker.now = func() time.Time { return now }
+
+			// the error neither resets nor adds to the failures of a closed breaker
+			breaker.Record(&ServerError{})
+			breaker.Record(tc.Err)
+			breaker.Record(&ServerError{})
+			if breaker.State() != CircuitOpen {
+				t.Fatalf("expected the breaker to be open, got %s", breaker.State())
+			}
+
+			// a probe ending with the error does not close the breaker, the next caller probes again
+			now = now.Add(time.Minute)
+			if err := breaker.Allow(); err != nil {
+				t.Fatalf("expected a probe to be allowed, got %v", err)
+			}
+			breaker.Record(tc.Err)
+			if breaker.State() == CircuitClosed {
+				t.Fatalf("expected the breaker not to close")
+			}
+			if err := breaker.Allow(); err != nil {
+				t.Fatalf("expected another probe to be allowed, got %v", err)
+			}
+			breaker.Record(nil)
+			if breaker.State() != CircuitClosed {
+				t.Fatalf("expected a success to close the breaker, got %s", breaker.State())
+			}
+		})
+	}
+}
+
+func TestSetCircuitBreakerNil(t *testing.T) {
+	c := newTestClient(0)
+	c.SetCircuitBreaker(NewCircuitBreaker(1, time.Minute))
+	c.SetCircuitBreaker(nil)
+	if c.CircuitBreakerState() != CircuitClosed {
+		t.Fatalf("expected no breaker, got %s", c.CircuitBreakerState())
+	}
+}
+
+func TestRegisterSendMetric(t *testing.T) {
+	_ = newTestClient(0)
+	// both metrics are already registered, each registration is attempted and reports its error
+	err := registerSendMetric("insightsclient_test")
+	var agg utilerrors.Aggregate
+	if !errors.As(err, &agg) || len(agg.Errors()) != 2 {
+		t.Fatalf("expected both registrations to fail, got %v", err)
+	}
+}
+
+func TestSendProxyAuthorization(tt *testing.T) {
+	testCases := []struct {
+		Name          string
+		Proxy         func(proxyURL string) string
+		Authorization string
+		// Endpoint the upload endpoint, requests to hosts outside of *.redhat.test use the fallback proxy
+		Endpoint string
+		Expected string
+		Fallback bool
+	}{
+		{
+			Name:     "Credentials of the proxy URL",
+			Proxy:    func(proxyURL string) string { return strings.Replace(proxyURL, "http://", "http://user:secret@", 1) },
+			Expected: "Basic dXNlcjpzZWNyZXQ=",
+		},
+		{
+			Name:          "Configured Proxy-Authorization header",
+			Proxy:         func(proxyURL string) string { return proxyURL },
+			Authorization: "Bearer token",
+			Expected:      "Bearer token",
+		},
+		{
+			Name:          "Fallback proxy gets no Proxy-Authorization header",
+			Proxy:         func(proxyURL string) string { return proxyURL },
+			Authorization: "Bearer token",
+			Endpoint:      "https://console.example.test/api/ingress/v1/upload",
+			Fallback:      true,
+		},
+		{
+			Name:     "Fallback proxy gets no credentials of the proxy URL",
+			Proxy:    func(proxyURL string) string { return strings.Replace(proxyURL, "http://", "http://user:secret@", 1) },
+			Endpoint: "https://console.example.test/api/ingress/v1/upload",
+			Fallback: true,
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			// stand-in proxies refusing every CONNECT after recording the credentials
+			newProxy := func(connects *[]string) *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method == http.MethodConnect {
+						*connects = append(*connects, r.Header.Get("Proxy-Authorization"))
+					}
+					w.WriteHeader(http.StatusProxyAuthRequired)
+				}))
+			}
+			var connects, fallbackConnects []string
+			proxy := newProxy(&connects)
+			defer proxy.Close()
+			fallbackProxy := newProxy(&fallbackConnects)
+			defer fallbackProxy.Close()
+
+			fallback, err := proxycontrol.NewRuleProxyControl([]proxycontrol.ProxyRule{{Host: "*", Proxy: fallbackProxy.URL}}, nil)
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			rules, err := proxycontrol.NewRuleProxyControl([]proxycontrol.ProxyRule{
+				{Host: "*.redhat.test", Proxy: tc.Proxy(proxy.URL), Authorization: tc.Authorization},
+			}, fallback)
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			var proxyCtrl proxycontrol.ProxyControl = rules
+			c := New(nil, 0, "", "insightsclient_test", &proxyCtrl, requestdecorator.New(nil, nil))
+
+			endpoint := tc.Endpoint
+			if endpoint == "" {
+				endpoint = "https://console.redhat.test/api/ingress/v1/upload"
+			}
+			_, err = c.Send(context.Background(), endpoint, source.Source{
+				Type:     "application/vnd.redhat.openshift.periodic+tgz",
+				Contents: bytes.NewReader([]byte("payload")),
+			})
+			var e *TransportError
+			if !errors.As(err, &e) || e.Proxy == "" {
+				t.Fatalf("expected a transport error with the proxy decision, got %v", err)
+			}
+			if strings.Contains(err.Error(), "secret") {
+				t.Fatalf("error leaks the proxy password: %v", err)
+			}
+			if tc.Fallback {
+				if len(connects) != 0 || len(fallbackConnects) != 1 || fallbackConnects[0] != "" {
+					t.Fatalf("unexpected Proxy-Authorization %q sent to the fallback proxy", fallbackConnects)
+				}
+				return
+			}
+			if !strings.Contains(e.Proxy, "proxy rule *.redhat.test matches console.redhat.test") {
+				t.Fatalf("unexpected proxy decision %s", e.Proxy)
+			}
+			if len(connects) != 1 || connects[0] != tc.Expected || len(fallbackConnects) != 0 {
+				t.Fatalf("unexpected Proxy-Authorization %q", connects)
+			}
+		})
+	}
+}
+
+func TestUnauthorizedRetry(t *testing.T) {
+	issued := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued++
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, issued)
+	}))
+	defer tokens.Close()
+	// the first token is revoked before it expires
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			_, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if f, _, err := r.FormFile("file"); err == nil {
+			data, _ := ioutil.ReadAll(f)
+			received = string(data)
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	var authorizer requestauthorizer.RequestAuthorizer = clientcredentialsauthorizer.New(clientcredentialsauthorizer.Config{
+		ClientID:     "svc",
+		ClientSecret: "secret",
+		TokenURL:     tokens.URL,
+	})
+	newClient := func() *Client {
+		issued = 0
+		return New(nil, 0, "", "insightsclient_test", &proxyCtrl, requestdecorator.New(nil, &authorizer))
+	}
+
+	// a download is retried with a new token
+	c := newClient()
+	if _, _, err := c.Download(context.Background(), srv.URL); err != nil || issued != 2 {
+		t.Fatalf("unexpected err %v after %d tokens", err, issued)
+	}
+
+	// an upload of parts that can be read again is streamed once more with a new token
+	authorizer.(requestauthorizer.TokenInvalidator).InvalidateToken()
+	c = newClient()
+	send := func(contents io.Reader) error {
+		_, err := c.Send(context.Background(), srv.URL, source.Source{
+			Type:     "application/vnd.redhat.openshift.periodic+tgz",
+			Contents: contents,
+		})
+		return err
+	}
+	payload := bytes.NewReader([]byte("payload"))
+	if err := send(payload); err != nil || issued != 2 || received != "payload" {
+		t.Fatalf("unexpected err %v after %d tokens, received %q", err, issued, received)
+	}
+
+	// a streamed upload that can not be read again only drops the rejected token, the next one uses a new token
+	authorizer.(requestauthorizer.TokenInvalidator).InvalidateToken()
+	c = newClient()
+	var e *UnauthorizedError
+	if err := send(bytes.NewBufferString("payload")); !errors.As(err, &e) {
+		t.Fatalf("expected an authorization error, got %v", err)
+	}
+	if err := send(bytes.NewBufferString("payload")); err != nil || issued != 2 || received != "payload" {
+		t.Fatalf("unexpected err %v after %d tokens, received %q", err, issued, received)
+	}
+}
+
+func TestUnauthorizedStaticToken(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	// the chain caches no token for a static link, there is nothing to retry with
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	var authorizer requestauthorizer.RequestAuthorizer = chainauthorizer.New(chainauthorizer.BearerTokenLink("pull-secret", "token"))
+	c := New(nil, 0, "", "insightsclient_test", &proxyCtrl, requestdecorator.New(nil, &authorizer))
+	_, err := c.Send(context.Background(), srv.URL, source.Source{
+		Type:     "application/vnd.redhat.openshift.periodic+tgz",
+		Contents: bytes.NewReader([]byte("payload")),
+	})
+	var e *UnauthorizedError
+	if !errors.As(err, &e) || requests != 1 {
+		t.Fatalf("expected a single rejected request, got %v after %d requests", err, requests)
+	}
+}
+
+type failingAuthorizer struct {
+	err error
+}
+
+func (a failingAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	return a.err
+}
+
+func TestSetupRequestAuthorization(t *testing.T) {
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	tokenErr := &oauth2token.Error{StatusCode: http.StatusUnauthorized, Code: "unauthorized_client"}
+	c := New(nil, 0, "", "insightsclient_test", &proxyCtrl,
+		requestdecorator.NewWithContextAuthorizer(nil, failingAuthorizer{err: tokenErr}))
+	_, err := c.SetupRequest(context.Background(), "GET", "https://console.redhat.com/api", nil, "")
+	var authErr *AuthorizationError
+	var cause *oauth2token.Error
+	if !errors.As(err, &authErr) || !authorizer.IsAuthorizationError(err) || !errors.As(err, &cause) {
+		t.Fatalf("expected a typed authorization error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var bearer requestauthorizer.RequestAuthorizer = bearertokenauthorizer.New("token")
+	c = New(nil, 0, "", "insightsclient_test", &proxyCtrl, requestdecorator.New(nil, &bearer))
+	if _, err := c.SetupRequest(ctx, "GET", "https://console.redhat.com/api", nil, ""); !errors.Is(err, context.Canceled) || authorizer.IsAuthorizationError(err) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	req, err := c.SetupRequest(context.Background(), "GET", "https://console.redhat.com/api", nil, "")
+	if err != nil || req.Header.Get("Authorization") != "Bearer token" {
+		t.Fatalf("unexpected request %v %v", req, err)
+	}
+}
+
+func TestSendResultAuthorizer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	var authorizer requestauthorizer.RequestAuthorizer = chainauthorizer.New(chainauthorizer.BearerTokenLink("pull-secret", "token"))
+	c := New(nil, 0, "", "insightsclient_test", &proxyCtrl, requestdecorator.New(nil, &authorizer))
+	result, err := c.Send(context.Background(), srv.URL, source.Source{
+		Type:     "application/vnd.redhat.openshift.periodic+tgz",
+		Contents: bytes.NewReader([]byte("payload")),
+	})
+	if err != nil || result.Authorizer != "pull-secret" {
+		t.Fatalf("unexpected result %+v %v", result, err)
+	}
+}
+
+func TestSendSigned(t *testing.T) {
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		verifyErr = requestsigner.Verify(r.Trailer, body, []byte("secret"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(0)
+	c.SetSigner(requestsigner.New([]byte("secret")))
+	if _, err := c.Send(context.Background(), srv.URL, source.Source{
+		Type:     "application/vnd.redhat.openshift.periodic+tgz",
+		Contents: bytes.NewReader([]byte("payload")),
+	}); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if verifyErr != nil {
+		t.Fatalf("unexpected verification err %s", verifyErr)
+	}
+}
@@ -0,0 +1,173 @@
+package insightsclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// payloadStatusMaxBytes The largest payload tracker response that will be decoded
+	payloadStatusMaxBytes = 1024 * 1024
+)
+
+// PayloadState The processing state of an uploaded payload
+type PayloadState string
+
+const (
+	// PayloadUnknown the payload tracker has no record of the payload yet
+	PayloadUnknown PayloadState = "unknown"
+	// PayloadReceived the payload was received by ingress
+	PayloadReceived PayloadState = "received"
+	// PayloadProcessing a downstream service is processing the payload
+	PayloadProcessing PayloadState = "processing"
+	// PayloadSuccess the payload was processed successfully
+	PayloadSuccess PayloadState = "success"
+	// PayloadError processing of the payload failed
+	PayloadError PayloadState = "error"
+)
+
+// Done Returns true if the state is final
+func (s PayloadState) Done() bool {
+	return s == PayloadSuccess || s == PayloadError
+}
+
+// PayloadEvent A single status record reported by a service to the payload tracker
+type PayloadEvent struct {
+	Service string    `json:"service"`
+	Status  string    `json:"status"`
+	Message string    `json:"status_msg"`
+	Date    time.Time `json:"date"`
+}
+
+// PayloadStatus The processing status of a payload as reported by the payload tracker
+type PayloadStatus struct {
+	RequestID string
+	State     PayloadState
+	// Message the status message of the event that decided the state
+	Message string
+	Events  []PayloadEvent
+}
+
+type payloadTrackerResponse struct {
+	Data []PayloadEvent `json:"data"`
+}
+
+// PollOptions Controls how WaitForPayload polls the payload tracker
+type PollOptions struct {
+	// Interval the delay before the first poll, doubled after every poll
+	Interval time.Duration
+	// MaxInterval the upper bound of the delay between polls
+	MaxInterval time.Duration
+	// Timeout the overall time to wait for a final state
+	Timeout time.Duration
+}
+
+func (o PollOptions) withDefaults() PollOptions {
+	if o.Interval <= 0 {
+		o.Interval = 5 * time.Second
+	}
+	if o.MaxInterval <= 0 {
+		o.MaxInterval = time.Minute
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Minute
+	}
+	return o
+}
+
+// PayloadStatus Gets the processing status of a payload, the request ID is appended to the endpoint path
+func (c *Client) PayloadStatus(ctx context.Context, endpoint string, requestID string) (*PayloadStatus, error) {
+	uri := strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(requestID)
+	req, err := c.SetupRequest(ctx, "GET", uri, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, c.transportError(req, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			klog.Warningf("Failed to close response body: %v", err)
+		}
+	}()
+
+	status := &PayloadStatus{RequestID: requestID, State: PayloadUnknown}
+	if resp.StatusCode == http.StatusNotFound {
+		// the tracker only learns about a payload once ingress has announced it
+		return status, nil
+	}
+	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+		return nil, responseError(resp, requestID)
+	}
+
+	var tracker payloadTrackerResponse
+	if err := json.NewDecoder(NewLimitReadCloser(resp.Body, payloadStatusMaxBytes)).Decode(&tracker); err != nil {
+		return nil, fmt.Errorf("unable to decode payload tracker response: %v", err)
+	}
+	status.Events = tracker.Data
+	status.State, status.Message = payloadState(tracker.Data)
+	return status, nil
+}
+
+// payloadState reduces the events of all services to one state, an error anywhere wins over success
+func payloadState(events []PayloadEvent) (PayloadState, string) {
+	state, message := PayloadUnknown, ""
+	rank := map[PayloadState]int{PayloadUnknown: 0, PayloadReceived: 1, PayloadProcessing: 2, PayloadSuccess: 3, PayloadError: 4}
+	for _, e := range events {
+		s := PayloadState(strings.ToLower(e.Status))
+		if _, ok := rank[s]; !ok {
+			// services report intermediate states with their own names
+			s = PayloadProcessing
+		}
+		if rank[s] >= rank[state] {
+			state, message = s, e.Message
+		}
+	}
+	return state, message
+}
+
+// WaitForPayload Polls the payload tracker with exponential backoff until the payload reaches a final
+// state, the timeout expires or the tracker rejects the request with a 4xx status other than 429, in
+// which case the last known status is returned with the error
+func (c *Client) WaitForPayload(ctx context.Context, endpoint string, requestID string, opts PollOptions) (*PayloadStatus, error) {
+	opts = opts.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	last := &PayloadStatus{RequestID: requestID, State: PayloadUnknown}
+	interval := opts.Interval
+	for {
+		select {
+		case <-ctx.Done():
+			return last, fmt.Errorf("payload %s did not finish processing: %v", requestID, ctx.Err())
+		case <-time.After(interval):
+		}
+		status, err := c.PayloadStatus(ctx, endpoint, requestID)
+		if err != nil {
+			if code := StatusCode(err); code >= 400 && code < 500 && code != http.StatusTooManyRequests {
+				// a 404 is not an error before ingress announced the payload, any other 4xx will not change
+				return last, fmt.Errorf("payload %s can not be tracked: %w", requestID, err)
+			}
+			klog.V(4).Infof("Unable to get the status of payload %s: %v", requestID, err)
+		} else {
+			klog.V(4).Infof("Payload %s is %s", requestID, status.State)
+			last = status
+			if status.State.Done() {
+				return status, nil
+			}
+		}
+		interval *= 2
+		if interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+	}
+}
@@ -33,7 +33,7 @@ func LimitReader(r io.Reader, n int64) io.Reader { return &LimitedReader{r, n} }
 // A LimitedReader reads from R but limits the amount of
 // data returned to just N bytes. Each call to Read
 // updates N to reflect the new amount remaining.
-// Read returns ErrTooLong when N <= 0 or when the underlying R returns EOF.
+// Read returns ErrTooLong once R has more than N bytes, R ending after exactly N bytes is not an error.
 type LimitedReader struct {
 	R io.Reader // underlying reader
 	N int64     // max bytes remaining
@@ -41,7 +41,13 @@ type LimitedReader struct {
 
 func (l *LimitedReader) Read(p []byte) (n int, err error) {
 	if l.N <= 0 {
-		return 0, ErrTooLong
+		// at the limit, only the end of R keeps the data within it
+		var probe [1]byte
+		n, err = l.R.Read(probe[:])
+		if n > 0 {
+			return 0, ErrTooLong
+		}
+		return 0, err
 	}
 	if int64(len(p)) > l.N {
 		p = p[0:l.N]
@@ -0,0 +1,172 @@
+package insightsclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// ErrNotModified An error for a download answered with 304 when nothing was cached for the endpoint
+var ErrNotModified = errors.New("content not modified")
+
+// ReportRule A single recommendation of an Insights report
+type ReportRule struct {
+	RuleID      string          `json:"rule_id"`
+	ErrorKey    string          `json:"error_key,omitempty"`
+	Description string          `json:"description"`
+	TotalRisk   int             `json:"total_risk"`
+	Details     json.RawMessage `json:"details,omitempty"`
+	CreatedAt   string          `json:"created_at,omitempty"`
+}
+
+// ReportMeta The metadata of an Insights report
+type ReportMeta struct {
+	Count         int       `json:"count"`
+	LastCheckedAt time.Time `json:"last_checked_at"`
+}
+
+// Report The processed Insights report of a cluster
+type Report struct {
+	Meta ReportMeta   `json:"meta"`
+	Data []ReportRule `json:"data"`
+	// ETag the entity tag the report was served with
+	ETag string `json:"-"`
+}
+
+type reportResponse struct {
+	Report Report `json:"report"`
+	Status string `json:"status"`
+}
+
+// Severity names for the total_risk of a rule
+const (
+	SeverityLow       = "low"
+	SeverityModerate  = "moderate"
+	SeverityImportant = "important"
+	SeverityCritical  = "critical"
+)
+
+// CountBySeverity Returns the number of recommendations for each severity
+func (r *Report) CountBySeverity() map[string]int {
+	counts := map[string]int{
+		SeverityLow:       0,
+		SeverityModerate:  0,
+		SeverityImportant: 0,
+		SeverityCritical:  0,
+	}
+	for _, rule := range r.Data {
+		switch {
+		case rule.TotalRisk >= 4:
+			counts[SeverityCritical]++
+		case rule.TotalRisk == 3:
+			counts[SeverityImportant]++
+		case rule.TotalRisk == 2:
+			counts[SeverityModerate]++
+		default:
+			counts[SeverityLow]++
+		}
+	}
+	return counts
+}
+
+// downloadCache remembers the last body served for each endpoint with its entity tag
+type downloadCache struct {
+	lock    sync.Mutex
+	entries map[string]downloadCacheEntry
+}
+
+type downloadCacheEntry struct {
+	etag string
+	body []byte
+}
+
+func (d *downloadCache) get(endpoint string) (downloadCacheEntry, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	e, ok := d.entries[endpoint]
+	return e, ok
+}
+
+func (d *downloadCache) set(endpoint string, e downloadCacheEntry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.entries == nil {
+		d.entries = make(map[string]downloadCacheEntry)
+	}
+	d.entries[endpoint] = e
+}
+
+// Download Gets the body of endpoint limited to maxBytes of the client. The last body of every
+// endpoint is cached and revalidated with If-None-Match, so a 304 returns the cached body.
+func (c *Client) Download(ctx context.Context, endpoint string) ([]byte, string, error) {
+	req, err := c.SetupRequest(ctx, "GET", endpoint, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	cached, hasCache := c.downloads.get(endpoint)
+	if hasCache && len(cached.etag) > 0 {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	klog.V(4).Infof("Downloading %s", req.URL.String())
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", c.transportError(req, err)
+	}
+	defer func() {
+		if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+			klog.Warningf("error copying body: %v", err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			klog.Warningf("Failed to close response body: %v", err)
+		}
+	}()
+	requestID := resp.Header.Get("x-rh-insights-request-id")
+
+	if resp.StatusCode == http.StatusNotModified {
+		if !hasCache {
+			return nil, "", ErrNotModified
+		}
+		klog.V(4).Infof("Content of %s not modified, using cached copy", req.URL.String())
+		return cached.body, cached.etag, nil
+	}
+	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+		return nil, "", responseError(resp, requestID)
+	}
+
+	// read one byte past the limit, a body of exactly maxBytes is complete
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxBytes+1))
+	if err != nil {
+		return nil, "", &TransportError{Err: err}
+	}
+	if int64(len(body)) > c.maxBytes {
+		return nil, "", &TooLongError{MaxBytes: c.maxBytes}
+	}
+	etag := resp.Header.Get("ETag")
+	c.downloads.set(endpoint, downloadCacheEntry{etag: etag, body: body})
+	return body, etag, nil
+}
+
+// GetReport Downloads and decodes the Insights report of the cluster
+func (c *Client) GetReport(ctx context.Context, endpoint string) (*Report, error) {
+	body, etag, err := c.Download(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var resp reportResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode the Insights report: %v", err)
+	}
+	report := resp.Report
+	report.ETag = etag
+	return &report, nil
+}
@@ -0,0 +1,51 @@
+package insightsclient
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// acknowledgementMaxBytes The largest acknowledgement body that will be decoded
+	acknowledgementMaxBytes = 64 * 1024
+)
+
+// UploadMetadata The identity information ingress echoes back for an accepted upload
+type UploadMetadata struct {
+	AccountNumber string `json:"account_number,omitempty"`
+	OrgID         string `json:"org_id,omitempty"`
+}
+
+// SendResult The acknowledgement of an accepted upload
+type SendResult struct {
+	// RequestID the x-rh-insights-request-id used to correlate with the payload tracker
+	RequestID  string         `json:"request_id"`
+	Upload     UploadMetadata `json:"upload"`
+	StatusCode int            `json:"-"`
+	// Authorizer the name of the authorizer of the upload, empty unless a chain of authorizers recorded it
+	Authorizer string `json:"-"`
+	// Raw the undecoded acknowledgement body
+	Raw json.RawMessage `json:"-"`
+}
+
+// decodeSendResult reads the acknowledgement body, a body that is not JSON still yields the request ID from the header
+func decodeSendResult(resp *http.Response, requestID string) (*SendResult, error) {
+	result := &SendResult{}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, acknowledgementMaxBytes))
+	if err != nil {
+		return &SendResult{RequestID: requestID, StatusCode: resp.StatusCode}, err
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, result); err != nil {
+			result = &SendResult{}
+		}
+		result.Raw = body
+	}
+	if result.RequestID == "" {
+		result.RequestID = requestID
+	}
+	result.StatusCode = resp.StatusCode
+	return result, nil
+}
@@ -0,0 +1,350 @@
+package insightsclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
+)
+
+// The resumable upload protocol:
+//
+//   POST   <endpoint>                  initiate, JSON resumableInitiate, answers 201 with resumableStatus
+//   GET    <endpoint>/<id>             status, answers resumableStatus with the acknowledged offset
+//   PUT    <endpoint>/<id>             chunk at the Upload-Offset header with a Digest header,
+//                                      answers resumableStatus or 409 with the acknowledged offset
+//   POST   <endpoint>/<id>/finalize    JSON resumableFinalize, answers like a regular upload
+
+const (
+	// uploadOffsetHeader The header carrying the offset of a chunk
+	uploadOffsetHeader = "Upload-Offset"
+	// resumableStatusMaxBytes The largest status response that will be decoded
+	resumableStatusMaxBytes = 64 * 1024
+)
+
+// ResumableOptions Controls a resumable upload
+type ResumableOptions struct {
+	// UploadID resumes an upload started earlier, a new upload is initiated when empty
+	UploadID string
+	// ChunkSize the number of bytes sent per request
+	ChunkSize int64
+	// MaxRetries the number of consecutive failures tolerated before giving up
+	MaxRetries int
+	// RetryDelay the delay before resuming after a failure, doubled after every consecutive failure
+	RetryDelay time.Duration
+}
+
+func (o ResumableOptions) withDefaults() ResumableOptions {
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = 1024 * 1024
+	}
+	if o.MaxRetries <= 0 {
+		o.MaxRetries = 5
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = time.Second
+	}
+	return o
+}
+
+// ResumableError An error for an upload that failed for good, UploadID allows resuming it later
+type ResumableError struct {
+	UploadID string
+	Offset   int64
+	Err      error
+}
+
+// Error Obtains the error string from the error object
+func (e *ResumableError) Error() string {
+	return fmt.Sprintf("resumable upload %s stopped at offset %d: %v", e.UploadID, e.Offset, e.Err)
+}
+
+// Unwrap Returns the error that stopped the upload
+func (e *ResumableError) Unwrap() error {
+	return e.Err
+}
+
+type resumableInitiate struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+type resumableStatus struct {
+	UploadID string `json:"upload_id"`
+	Offset   int64  `json:"offset"`
+}
+
+type resumableFinalize struct {
+	SHA256 string `json:"sha256"`
+}
+
+// SendResumable Uploads size bytes of payload in chunks, resuming from the last chunk acknowledged
+// by the server after a failure. The maxBytes limit of the client does not apply, its limiter and
+// circuit breaker do.
+func (c *Client) SendResumable(ctx context.Context, endpoint string, filename string, contentType string,
+	payload io.ReaderAt, size int64, opts ResumableOptions) (*SendResult, error) {
+	if err := c.breaker.Allow(); err != nil {
+		klog.V(4).Infof("Not uploading: %v", err)
+		return nil, err
+	}
+	result, err := c.sendResumable(ctx, endpoint, filename, contentType, payload, size, opts)
+	c.breaker.Record(err)
+	return result, err
+}
+
+func (c *Client) sendResumable(ctx context.Context, endpoint string, filename string, contentType string,
+	payload io.ReaderAt, size int64, opts ResumableOptions) (*SendResult, error) {
+	opts = opts.withDefaults()
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	digest, err := readerAtDigest(payload, size)
+	if err != nil {
+		return nil, err
+	}
+
+	id := opts.UploadID
+	var offset int64
+	if id == "" {
+		status, err := c.resumableRequest(ctx, "POST", endpoint, resumableInitiate{
+			Filename:    filename,
+			ContentType: contentType,
+			Size:        size,
+			SHA256:      digest,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		id = status.UploadID
+		if id == "" {
+			return nil, fmt.Errorf("resumable upload was not assigned an id")
+		}
+		klog.V(4).Infof("Initiated resumable upload %s of %d bytes", id, size)
+	}
+	uploadURL := endpoint + "/" + url.PathEscape(id)
+
+	failures := 0
+	synced := opts.UploadID == ""
+	for {
+		if !synced {
+			status, err := c.resumableRequest(ctx, "GET", uploadURL, nil, nil)
+			if err != nil {
+				if failures, err = c.resumableRetry(ctx, opts, failures, err); err != nil {
+					return nil, &ResumableError{UploadID: id, Offset: offset, Err: err}
+				}
+				continue
+			}
+			if status.Offset > offset {
+				// the server kept part of the failed chunk, the upload is still making progress
+				failures = 0
+			}
+			offset = status.Offset
+			synced = true
+			klog.V(4).Infof("Resuming upload %s at offset %d", id, offset)
+		}
+		if offset >= size {
+			break
+		}
+
+		n := opts.ChunkSize
+		if size-offset < n {
+			n = size - offset
+		}
+		chunk := make([]byte, n)
+		// ReadAt may return io.EOF along with a full chunk at the end of the payload
+		if read, err := payload.ReadAt(chunk, offset); read != len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, &ResumableError{UploadID: id, Offset: offset,
+				Err: fmt.Errorf("unable to read %d bytes of the payload: %w", len(chunk), err)}
+		}
+		sum := sha256.Sum256(chunk)
+		header := http.Header{}
+		header.Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+		header.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		status, err := c.resumableRequest(ctx, "PUT", uploadURL, chunk, header)
+		if err == nil && status.Offset <= offset {
+			err = fmt.Errorf("%w at offset %d", errUnacknowledgedChunk, offset)
+		}
+		if err != nil {
+			// the server may have stored part of the chunk, ask where to continue
+			synced = false
+			if failures, err = c.resumableRetry(ctx, opts, failures, err); err != nil {
+				return nil, &ResumableError{UploadID: id, Offset: offset, Err: err}
+			}
+			continue
+		}
+		failures = 0
+		offset = status.Offset
+	}
+
+	result, err := c.finalizeResumable(ctx, uploadURL+"/finalize", digest)
+	if err != nil {
+		return nil, &ResumableError{UploadID: id, Offset: offset, Err: err}
+	}
+	klog.V(2).Infof("Successfully reported resumable upload %s x-rh-insights-request-id=%s, wrote=%d", id, result.RequestID, size)
+	return result, nil
+}
+
+// errUnacknowledgedChunk An error for a chunk answered without moving the offset forward
+var errUnacknowledgedChunk = errors.New("server did not acknowledge the chunk")
+
+// resumableRetryable Returns true for the failures worth another attempt: transport errors,
+// 5xx, 408, 429 and 409 or unacknowledged chunks that only require syncing the offset
+func resumableRetryable(err error) bool {
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) || errors.Is(err, errUnacknowledgedChunk) {
+		return true
+	}
+	code := StatusCode(err)
+	return code >= 500 ||
+		code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests ||
+		code == http.StatusConflict
+}
+
+// resumableRetry waits before the next attempt and fails at once for errors that are not
+// retryable or once the retries are exhausted
+func (c *Client) resumableRetry(ctx context.Context, opts ResumableOptions, failures int, err error) (int, error) {
+	if !resumableRetryable(err) {
+		return failures, err
+	}
+	failures++
+	if failures > opts.MaxRetries {
+		return failures, err
+	}
+	delay := opts.RetryDelay << uint(failures-1)
+	var rateLimited *RateLimitedError
+	if errors.As(err, &rateLimited) && rateLimited.RetryAfter > delay {
+		delay = rateLimited.RetryAfter
+	}
+	klog.V(4).Infof("Resumable upload failed (attempt %d of %d), retrying in %s: %v", failures, opts.MaxRetries, delay, err)
+	select {
+	case <-ctx.Done():
+		return failures, ctx.Err()
+	case <-time.After(delay):
+	}
+	return failures, nil
+}
+
+// resumableRequest sends a protocol request, body is JSON encoded unless it is a chunk of bytes
+func (c *Client) resumableRequest(ctx context.Context, method, uri string, body interface{}, header http.Header) (*resumableStatus, error) {
+	var buf *bytes.Buffer
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		buf = bytes.NewBuffer(b)
+		contentType = "application/octet-stream"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		buf = bytes.NewBuffer(data)
+		contentType = "application/json"
+	}
+	req, err := c.SetupRequest(ctx, method, uri, buf, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.limiter.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	if chunk, ok := body.([]byte); ok {
+		// chunks count against the bandwidth limit, including a chunk sent again after a new token
+		req.Body = c.limiter.ReadCloser(ctx, req.Body)
+		req.GetBody = func() (io.ReadCloser, error) {
+			return c.limiter.ReadCloser(ctx, ioutil.NopCloser(bytes.NewReader(chunk))), nil
+		}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, c.transportError(req, err)
+	}
+	defer func() {
+		if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+			klog.Warningf("error copying body: %v", err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			klog.Warningf("Failed to close response body: %v", err)
+		}
+	}()
+	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+		return nil, responseError(resp, resp.Header.Get("x-rh-insights-request-id"))
+	}
+	var status resumableStatus
+	if err := json.NewDecoder(NewLimitReadCloser(resp.Body, resumableStatusMaxBytes)).Decode(&status); err != nil {
+		return nil, fmt.Errorf("unable to decode resumable upload status: %v", err)
+	}
+	return &status, nil
+}
+
+func (c *Client) finalizeResumable(ctx context.Context, uri string, digest string) (*SendResult, error) {
+	data, err := json.Marshal(resumableFinalize{SHA256: digest})
+	if err != nil {
+		return nil, err
+	}
+	ctx, authorizerName := requestauthorizer.WithAuthorizerRecord(ctx)
+	req, err := c.SetupRequest(ctx, "POST", uri, bytes.NewBuffer(data), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.limiter.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		counterRequestSend.WithLabelValues(c.metricsName, "0", authorizerName()).Inc()
+		return nil, c.transportError(req, err)
+	}
+	defer func() {
+		if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+			klog.Warningf("error copying body: %v", err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			klog.Warningf("Failed to close response body: %v", err)
+		}
+	}()
+	counterRequestSend.WithLabelValues(c.metricsName, strconv.Itoa(resp.StatusCode), authorizerName()).Inc()
+	requestID := resp.Header.Get("x-rh-insights-request-id")
+	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+		return nil, responseError(resp, requestID)
+	}
+	result, err := decodeSendResult(resp, requestID)
+	if result != nil {
+		result.Authorizer = authorizerName()
+	}
+	return result, err
+}
+
+func readerAtDigest(r io.ReaderAt, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.CopyN(h, io.NewSectionReader(r, 0, size), size); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", fmt.Errorf("unable to read payload: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,352 @@
+package insightsclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/ratelimit"
+)
+
+// resumableServer A reference implementation of the server side of the resumable upload protocol
+type resumableServer struct {
+	lock    sync.Mutex
+	uploads map[string]*resumableServerUpload
+	nextID  int
+	// failChunk returns true for chunk requests that should fail
+	failChunk func(offset int64) bool
+	// partial makes failed chunk requests store the first half of the chunk
+	partial bool
+	// failStatus the status of failed chunk requests, 502 when zero
+	failStatus int
+	// chunkRequests counts the chunk requests received
+	chunkRequests int
+}
+
+type resumableServerUpload struct {
+	size   int64
+	sha256 string
+	data   []byte
+}
+
+func newResumableServer() *resumableServer {
+	return &resumableServer{uploads: make(map[string]*resumableServerUpload)}
+}
+
+func (s *resumableServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "POST" && len(parts) == 1:
+		var init resumableInitiate
+		if err := json.NewDecoder(r.Body).Decode(&init); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.nextID++
+		id := fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[id] = &resumableServerUpload{size: init.Size, sha256: init.SHA256}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(resumableStatus{UploadID: id})
+	case r.Method == "GET" && len(parts) == 2:
+		u, ok := s.uploads[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(resumableStatus{UploadID: parts[1], Offset: int64(len(u.data))})
+	case r.Method == "PUT" && len(parts) == 2:
+		u, ok := s.uploads[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+		if err != nil || offset != int64(len(u.data)) {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(resumableStatus{UploadID: parts[1], Offset: int64(len(u.data))})
+			return
+		}
+		s.chunkRequests++
+		chunk, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(chunk)
+		if r.Header.Get("Digest") != "sha-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s.failChunk != nil && s.failChunk(offset) {
+			if s.partial {
+				// simulate a connection dropped after part of the chunk was stored
+				u.data = append(u.data, chunk[:len(chunk)/2]...)
+			}
+			if s.failStatus != 0 {
+				w.WriteHeader(s.failStatus)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		u.data = append(u.data, chunk...)
+		_ = json.NewEncoder(w).Encode(resumableStatus{UploadID: parts[1], Offset: int64(len(u.data))})
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "finalize":
+		u, ok := s.uploads[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var fin resumableFinalize
+		_ = json.NewDecoder(r.Body).Decode(&fin)
+		sum := sha256.Sum256(u.data)
+		if int64(len(u.data)) != u.size || hex.EncodeToString(sum[:]) != u.sha256 || fin.SHA256 != u.sha256 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("x-rh-insights-request-id", parts[1])
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSendResumable(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	server := newResumableServer()
+	failed := map[int64]bool{}
+	server.partial = true
+	server.failChunk = func(offset int64) bool {
+		// every other chunk request fails
+		failed[offset] = len(failed)%2 == 0
+		return failed[offset]
+	}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	c := newTestClient(0)
+	result, err := c.SendResumable(context.Background(), srv.URL+"/uploads", "payload.tar.gz",
+		"application/vnd.redhat.openshift.periodic+tgz", bytes.NewReader(payload), int64(len(payload)),
+		ResumableOptions{ChunkSize: 1024, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if result.RequestID != "upload-1" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if !bytes.Equal(server.uploads["upload-1"].data, payload) {
+		t.Fatalf("server did not receive the payload")
+	}
+}
+
+func TestSendResumableGivesUp(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	server := newResumableServer()
+	server.failChunk = func(offset int64) bool {
+		return offset >= 2048
+	}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	c := newTestClient(0)
+	opts := ResumableOptions{ChunkSize: 1024, RetryDelay: time.Millisecond, MaxRetries: 2}
+	_, err := c.SendResumable(context.Background(), srv.URL+"/uploads", "payload.tar.gz",
+		"application/vnd.redhat.openshift.periodic+tgz", bytes.NewReader(payload), int64(len(payload)), opts)
+	resumableErr, ok := err.(*ResumableError)
+	if !ok || resumableErr.Offset != 2048 {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// once the server recovers the upload resumes from the acknowledged offset
+	server.failChunk = nil
+	opts.UploadID = resumableErr.UploadID
+	if _, err := c.SendResumable(context.Background(), srv.URL+"/uploads", "payload.tar.gz",
+		"application/vnd.redhat.openshift.periodic+tgz", bytes.NewReader(payload), int64(len(payload)), opts); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if !bytes.Equal(server.uploads[resumableErr.UploadID].data, payload) {
+		t.Fatalf("server did not receive the payload")
+	}
+}
+
+func TestSendResumableRetries(tt *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	testCases := []struct {
+		Status   int
+		Requests int
+	}{
+		{Status: http.StatusInternalServerError, Requests: 3},
+		{Status: http.StatusServiceUnavailable, Requests: 3},
+		{Status: http.StatusRequestTimeout, Requests: 3},
+		{Status: http.StatusTooManyRequests, Requests: 3},
+		{Status: http.StatusBadRequest, Requests: 1},
+		{Status: http.StatusUnauthorized, Requests: 1},
+		{Status: http.StatusForbidden, Requests: 1},
+		{Status: http.StatusRequestEntityTooLarge, Requests: 1},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(strconv.Itoa(tc.Status), func(t *testing.T) {
+			server := newResumableServer()
+			server.failStatus = tc.Status
+			server.failChunk = func(offset int64) bool {
+				return true
+			}
+			srv := httptest.NewServer(server)
+			defer srv.Close()
+
+			c := newTestClient(0)
+			opts := ResumableOptions{ChunkSize: 1024, RetryDelay: time.Millisecond, MaxRetries: 2}
+			_, err := c.SendResumable(context.Background(), srv.URL+"/uploads", "payload.tar.gz",
+				"application/vnd.redhat.openshift.periodic+tgz", bytes.NewReader(payload), int64(len(payload)), opts)
+			if StatusCode(err) != tc.Status {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if server.chunkRequests != tc.Requests {
+				t.Fatalf("expected %d chunk requests, got %d", tc.Requests, server.chunkRequests)
+			}
+		})
+	}
+}
+
+// shrinkingReaderAt A payload that loses its tail once it has been read shrinkAfter times
+type shrinkingReaderAt struct {
+	data        []byte
+	shrinkAfter int
+	reads       int
+}
+
+func (r *shrinkingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	data := r.data
+	if r.reads > r.shrinkAfter {
+		data = data[:len(data)/2]
+	}
+	return bytes.NewReader(data).ReadAt(p, off)
+}
+
+func TestSendResumableShortRead(tt *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	testCases := []struct {
+		Name    string
+		Payload io.ReaderAt
+		Chunks  int
+	}{
+		{Name: "Shorter than the size", Payload: bytes.NewReader(payload[:5000])},
+		// the digest takes the first reads, the payload shrinks while the fifth chunk is read
+		{Name: "Truncated while uploading", Payload: &shrinkingReaderAt{data: payload, shrinkAfter: 3}, Chunks: 4},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			server := newResumableServer()
+			srv := httptest.NewServer(server)
+			defer srv.Close()
+
+			c := newTestClient(0)
+			_, err := c.SendResumable(context.Background(), srv.URL+"/uploads", "payload.tar.gz",
+				"application/vnd.redhat.openshift.periodic+tgz", tc.Payload, int64(len(payload)),
+				ResumableOptions{ChunkSize: 1024, RetryDelay: time.Millisecond})
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("expected an unexpected EOF, got %v", err)
+			}
+			if server.chunkRequests != tc.Chunks {
+				t.Fatalf("expected %d chunk requests, got %d", tc.Chunks, server.chunkRequests)
+			}
+		})
+	}
+}
+
+func TestSendResumableCircuitBreaker(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	server := newResumableServer()
+	server.failStatus = http.StatusServiceUnavailable
+	server.failChunk = func(offset int64) bool {
+		return true
+	}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	c := newTestClient(0)
+	c.SetCircuitBreaker(breaker)
+	opts := ResumableOptions{ChunkSize: 1024, RetryDelay: time.Millisecond, MaxRetries: 1}
+	send := func() error {
+		_, err := c.SendResumable(context.Background(), srv.URL+"/uploads", "payload.tar.gz",
+			"application/vnd.redhat.openshift.periodic+tgz", bytes.NewReader(payload), int64(len(payload)), opts)
+		return err
+	}
+
+	var serverErr *ServerError
+	err := send()
+	if !errors.As(err, &serverErr) || c.CircuitBreakerState() != CircuitOpen {
+		t.Fatalf("expected a server error to open the breaker, got %v", err)
+	}
+	resumableErr := err.(*ResumableError)
+
+	// no request is sent while the breaker is open
+	sent := server.chunkRequests
+	var open *CircuitOpenError
+	if err := send(); !errors.As(err, &open) || server.chunkRequests != sent || len(server.uploads) != 1 {
+		t.Fatalf("expected the breaker to skip the upload, got %v", err)
+	}
+
+	// a successful probe resuming the upload closes it
+	now = now.Add(time.Minute)
+	server.failChunk = nil
+	opts.UploadID = resumableErr.UploadID
+	if err := send(); err != nil || c.CircuitBreakerState() != CircuitClosed {
+		t.Fatalf("expected the breaker to be closed, got %v", err)
+	}
+}
+
+func TestSendResumableLimited(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 1500)
+	server := newResumableServer()
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	// the chunks past the burst of one second worth of data wait for the bandwidth
+	c := newTestClient(0)
+	c.SetLimiter(ratelimit.New(10000, 0))
+	start := time.Now()
+	if _, err := c.SendResumable(context.Background(), srv.URL+"/uploads", "payload.tar.gz",
+		"application/vnd.redhat.openshift.periodic+tgz", bytes.NewReader(payload), int64(len(payload)),
+		ResumableOptions{ChunkSize: 1024}); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("the upload was not throttled, it took %s", elapsed)
+	}
+
+	// the initiate request takes the only request allowed this minute, the chunks wait for the next
+	sent := server.chunkRequests
+	c = newTestClient(0)
+	c.SetLimiter(ratelimit.New(0, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := c.SendResumable(ctx, srv.URL+"/uploads", "payload.tar.gz",
+		"application/vnd.redhat.openshift.periodic+tgz", bytes.NewReader(payload), int64(len(payload)),
+		ResumableOptions{ChunkSize: 1024})
+	if err == nil {
+		t.Fatalf("expected the request limit to stop the upload")
+	}
+	if server.chunkRequests != sent {
+		t.Fatalf("unexpected chunk requests %d", server.chunkRequests)
+	}
+}
@@ -0,0 +1,96 @@
+package insightsclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// scaResponseMaxBytes The largest certificate response that will be decoded
+	scaResponseMaxBytes = 1024 * 1024
+)
+
+// SCACertificates The simple content access certificate bundle of a cluster
+type SCACertificates struct {
+	ID   string `json:"id"`
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	// NotAfter the expiry of the certificate
+	NotAfter time.Time `json:"-"`
+}
+
+type scaRequest struct {
+	Type string `json:"type"`
+	Arch string `json:"arch"`
+}
+
+// ParseSCACertificates Checks the certificate and key form a valid pair and returns the certificate expiry
+func ParseSCACertificates(cert, key []byte) (time.Time, error) {
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		return time.Time{}, fmt.Errorf("invalid certificate and key pair: %v", err)
+	}
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("certificate is not PEM encoded")
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse certificate: %v", err)
+	}
+	return parsed.NotAfter, nil
+}
+
+// RequestSCACertificates Requests the simple content access certificates of the cluster. A non empty
+// token replaces the authorization of the request decorator with the pull secret bearer token.
+func (c *Client) RequestSCACertificates(ctx context.Context, endpoint string, token string, arch string) (*SCACertificates, error) {
+	body, err := json.Marshal(scaRequest{Type: "sca", Arch: arch})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.SetupRequest(ctx, "POST", endpoint, bytes.NewBuffer(body), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	req.Header.Set("Accept", "application/json")
+
+	klog.V(4).Infof("Requesting SCA certificates from %s", req.URL.String())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, c.transportError(req, err)
+	}
+	defer func() {
+		if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+			klog.Warningf("error copying body: %v", err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			klog.Warningf("Failed to close response body: %v", err)
+		}
+	}()
+	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+		return nil, responseError(resp, resp.Header.Get("x-rh-insights-request-id"))
+	}
+
+	var certs SCACertificates
+	if err := json.NewDecoder(NewLimitReadCloser(resp.Body, scaResponseMaxBytes)).Decode(&certs); err != nil {
+		return nil, fmt.Errorf("unable to decode SCA certificates: %v", err)
+	}
+	notAfter, err := ParseSCACertificates([]byte(certs.Cert), []byte(certs.Key))
+	if err != nil {
+		return nil, err
+	}
+	certs.NotAfter = notAfter
+	return &certs, nil
+}
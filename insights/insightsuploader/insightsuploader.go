@@ -2,10 +2,15 @@ package insightsuploader
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/klog"
@@ -14,24 +19,146 @@ import (
 	"github.com/redhatinsights/insights-ingress-http-client/config"
 	"github.com/redhatinsights/insights-ingress-http-client/controllerstatus"
 	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/payloadvalidator"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/redaction"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
 	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/uploadmetadata"
 )
 
+// defaultTrackingTimeout How long an accepted upload is tracked when the poll options set no timeout
+const defaultTrackingTimeout = 5 * time.Minute
+
 // Controller An object for processing an upload
 type Controller struct {
 	controllerstatus.Simple
 
 	client       *insightsclient.Client
 	configurator config.Configurator
+	redactor     *redaction.Redactor
+	metadata     *uploadmetadata.Builder
+
+	trackerEndpoint string
+	trackerOptions  insightsclient.PollOptions
+	// trackingCtx outlives the uploads, tracking stops once Stop cancels it
+	trackingCtx  context.Context
+	stopTracking context.CancelFunc
+
+	compression      source.Compression
+	compressionLevel int
+
+	lock            sync.Mutex
+	redactionReport *redaction.Report
+	sendResult      *insightsclient.SendResult
+	payloadStatus   *insightsclient.PayloadStatus
 }
 
 // New Initialize a new Controller object
 func New(client *insightsclient.Client, configurator config.Configurator) *Controller {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Controller{
 		Simple:       controllerstatus.Simple{Name: "insightsuploader"},
 		configurator: configurator,
 		client:       client,
+		trackingCtx:  ctx,
+		stopTracking: cancel,
+	}
+}
+
+// Stop Stops tracking the accepted uploads in the background
+func (c *Controller) Stop() {
+	c.stopTracking()
+}
+
+// SetRedactor Sets the redactor applied to every payload before it leaves the cluster
+func (c *Controller) SetRedactor(redactor *redaction.Redactor) {
+	c.redactor = redactor
+}
+
+// LastRedactionReport Returns the report of the last redacted payload, nil if none was redacted
+func (c *Controller) LastRedactionReport() *redaction.Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.redactionReport
+}
+
+// SetCompression Makes Upload take uncompressed tar payloads and compress them with the algorithm
+// and level while they are sent, zero selects the default level. CompressionNone, the default, takes
+// tar.gz payloads. A zstd payload is sent as payload.tar.zst with the +tgz suffix of the mime type
+// replaced by +zstd.
+func (c *Controller) SetCompression(compression source.Compression, level int) {
+	c.compression = compression
+	c.compressionLevel = level
+}
+
+// SetMetadataBuilder Sets the builder of the metadata part sent with every payload
+func (c *Controller) SetMetadataBuilder(metadata *uploadmetadata.Builder) {
+	c.metadata = metadata
+}
+
+// SetPayloadTracking Makes Upload follow the processing of every accepted upload on the payload tracker
+// at endpoint in the background, until it finishes, the timeout of opts expires or Stop is called. The
+// context of the upload does not apply, it usually ends with Upload. An empty endpoint disables tracking.
+func (c *Controller) SetPayloadTracking(endpoint string, opts insightsclient.PollOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTrackingTimeout
+	}
+	c.trackerEndpoint = endpoint
+	c.trackerOptions = opts
+}
+
+// LastPayloadStatus Returns the downstream processing status of the last tracked upload,
+// nil while it is still being tracked
+func (c *Controller) LastPayloadStatus() *insightsclient.PayloadStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.payloadStatus
+}
+
+func (c *Controller) trackPayload(requestID string) {
+	ctx, cancel := context.WithTimeout(c.trackingCtx, c.trackerOptions.Timeout)
+	defer cancel()
+	status, err := c.client.WaitForPayload(ctx, c.trackerEndpoint, requestID, c.trackerOptions)
+	c.lock.Lock()
+	if c.sendResult != nil && c.sendResult.RequestID != requestID {
+		// a later upload was accepted while this one was tracked
+		c.lock.Unlock()
+		return
+	}
+	c.payloadStatus = status
+	c.lock.Unlock()
+	if err != nil {
+		klog.V(2).Infof("Unable to confirm processing of the report: %v", err)
+		return
 	}
+	if status.State == insightsclient.PayloadError {
+		c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.Processing,
+			Reason: "ProcessingFailed", Message: fmt.Sprintf("Report %s was not processed: %s", requestID, status.Message)})
+		return
+	}
+	klog.V(2).Infof("Report %s was processed successfully", requestID)
+}
+
+// LastSendResult Returns the acknowledgement of the last accepted upload, nil if none was accepted
+func (c *Controller) LastSendResult() *insightsclient.SendResult {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sendResult
+}
+
+func (c *Controller) recordRedaction(reports <-chan *redaction.Report) {
+	report, ok := <-reports
+	if !ok || report == nil {
+		klog.V(4).Infof("Redaction did not complete, the redaction report is not recorded")
+		return
+	}
+	klog.V(2).Infof("Redacted %d values in %d files", report.Total(), report.Files)
+	for _, f := range report.Findings {
+		klog.V(4).Infof("Redacted %d values from %s with rule %s", f.Count, f.File, f.Rule)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.redactionReport = report
 }
 
 // Upload Execute the payload upload
@@ -52,51 +179,193 @@ func (c *Controller) Upload(ctx context.Context, data io.ReadCloser, mimeType st
 	}
 	defer data.Close()
 
+	if c.redactor != nil {
+		stream := c.redactor.Stream
+		if c.compression != source.CompressionNone {
+			stream = c.redactor.StreamTar
+		}
+		redacted, reports := stream(data)
+		defer c.recordRedaction(reports)
+		defer redacted.Close()
+		data = redacted
+	}
+
 	if enabled && len(endpoint) > 0 {
+		if c.client.CircuitBreakerState() == insightsclient.CircuitOpen {
+			klog.V(4).Infof("Not uploading the report while the circuit breaker is open")
+			c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.Uploading,
+				Reason: "CircuitOpen", Message: "Reporting is paused after repeated upload failures"})
+			return
+		}
 		// send the results
 		start := time.Now()
 		id := start.Format(time.RFC3339)
-		klog.V(4).Infof("Uploading report at %s", start.Format(time.RFC3339))
-		if err := c.client.Send(ctx, endpoint, source.Source{
-			ID:       id,
-			Type:     mimeType,
-			Contents: data,
-		}); err != nil {
-			klog.V(2).Infof("Unable to upload report after %s: %v", time.Now().Sub(start).Truncate(time.Second/100), err)
-			versionError := err == insightsclient.ErrWaitingForVersion || err == insightsclient.ErrObtainingForVersion
-			if versionError {
-				return
-			}
-			if authorizer.IsAuthorizationError(err) {
-				c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.Uploading,
-					Reason: "NotAuthorized", Message: fmt.Sprintf("Reporting was not allowed: %v", err)})
+		var metadata []byte
+		if c.metadata != nil {
+			var err error
+			if metadata, err = c.metadata.Encode(); err != nil {
+				c.uploadFailed(start, err)
 				return
 			}
+		}
+		// the metadata part is sent within the same byte limit as the payload
+		payload, err := c.validate(data, mimeType, c.client.MaxBytes()-int64(len(metadata)))
+		if err != nil {
+			klog.V(2).Infof("Refusing to upload an invalid report: %v", err)
 			c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.Uploading,
-				Reason: "UploadFailed", Message: fmt.Sprintf("Unable to report: %v", err)})
+				Reason: "InvalidPayload", Message: fmt.Sprintf("Report is not valid: %v", err)})
+			return
+		}
+		klog.V(4).Infof("Uploading report at %s", start.Format(time.RFC3339))
+		result, err := c.send(ctx, endpoint, c.payloadSource(id, payload, mimeType), metadata)
+		if err != nil {
+			c.uploadFailed(start, err)
 			return
 		}
 		klog.V(4).Infof("Uploaded report successfully in %s", time.Now().Sub(start))
+		klog.V(2).Infof("Report accepted with request_id=%s account=%s org_id=%s", result.RequestID, result.Upload.AccountNumber, result.Upload.OrgID)
+		c.lock.Lock()
+		c.sendResult = result
+		c.payloadStatus = nil
+		c.lock.Unlock()
 		c.Simple.UpdateStatus(controllerstatus.Summary{Healthy: true})
+		if len(c.trackerEndpoint) > 0 && len(result.RequestID) > 0 {
+			go c.trackPayload(result.RequestID)
+		}
 	} else {
 		klog.V(4).Info("Display report that would be sent")
 		// display what would have been sent (to ensure we always exercise source processing)
-		if err := reportToLogs(data, klog.V(4)); err != nil {
+		if err := reportToLogs(data, c.compression == source.CompressionNone, klog.V(4)); err != nil {
 			klog.Errorf("Unable to log upload: %v", err)
 		}
+		// read the rest so the redaction report covers the whole payload
+		_, _ = io.Copy(ioutil.Discard, data)
 		// we didn't actually report logs, so don't advance the report date
 	}
 }
 
-func reportToLogs(source io.Reader, klog klog.Verbose) error {
+// send uploads the payload, together with the metadata part when there is metadata
+func (c *Controller) send(ctx context.Context, endpoint string, data source.Source, metadata []byte) (*insightsclient.SendResult, error) {
+	if metadata == nil {
+		return c.client.Send(ctx, endpoint, data)
+	}
+	return c.client.SendParts(ctx, endpoint, data.ID, []source.Part{data.FilePart(), uploadmetadata.NewPart(metadata)})
+}
+
+// uploadFailed reports the reason an upload started at start failed in the controller status
+func (c *Controller) uploadFailed(start time.Time, err error) {
+	klog.V(2).Infof("Unable to upload report after %s: %v", time.Now().Sub(start).Truncate(time.Second/100), err)
+	versionError := err == insightsclient.ErrWaitingForVersion || err == insightsclient.ErrObtainingForVersion
+	if versionError {
+		return
+	}
+	if errors.Is(err, requestauthorizer.ErrNoCredentials) {
+		c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.Uploading,
+			Reason: "NoCredentials", Message: fmt.Sprintf("Reporting is skipped until credentials are available: %v", err)})
+		return
+	}
+	if authorizer.IsAuthorizationError(err) {
+		c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.Uploading,
+			Reason: "NotAuthorized", Message: fmt.Sprintf("Reporting was not allowed: %v", err)})
+		return
+	}
+	c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.Uploading,
+		Reason: uploadFailureReason(err), Message: fmt.Sprintf("Unable to report: %v", err)})
+}
+
+// uploadFailureReason maps a Send error to the reason reported in the controller status
+func uploadFailureReason(err error) string {
+	var (
+		badRequest       *insightsclient.BadRequestError
+		payloadTooLarge  *insightsclient.PayloadTooLargeError
+		unsupportedMedia *insightsclient.UnsupportedMediaTypeError
+		rateLimited      *insightsclient.RateLimitedError
+		serverError      *insightsclient.ServerError
+		transportError   *insightsclient.TransportError
+		circuitOpen      *insightsclient.CircuitOpenError
+	)
+	switch {
+	case errors.As(err, &circuitOpen):
+		return "CircuitOpen"
+	case errors.As(err, &badRequest):
+		return "BadRequest"
+	case errors.As(err, &payloadTooLarge), errors.Is(err, insightsclient.ErrTooLong):
+		return "PayloadTooLarge"
+	case errors.As(err, &unsupportedMedia):
+		return "UnsupportedMediaType"
+	case errors.As(err, &rateLimited):
+		return "RateLimited"
+	case errors.As(err, &serverError):
+		return "ServerError"
+	case errors.As(err, &transportError):
+		return "TransportError"
+	default:
+		return "UploadFailed"
+	}
+}
+
+// validate buffers the payload while checking it is at most maxBytes, so a rejected archive is never sent.
+// An uncompressed payload is buffered as it is and compressed while it is sent, it is compressed on the side
+// as it is checked so the limit applies to the compressed bytes.
+func (c *Controller) validate(data io.Reader, mimeType string, maxBytes int64) (io.Reader, error) {
+	var buf bytes.Buffer
+	if c.compression == source.CompressionNone {
+		// read one byte past the limit so an oversized payload is detected without buffering all of it
+		r := io.TeeReader(io.LimitReader(data, maxBytes+1), &buf)
+		if err := payloadvalidator.Validate(r, mimeType, maxBytes); err != nil {
+			return nil, err
+		}
+		return &buf, nil
+	}
+
+	lw := &insightsclient.LimitedWriter{W: ioutil.Discard, N: maxBytes}
+	cw, err := insightsclient.NewCompressor(lw, c.compression, c.compressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	err = payloadvalidator.ValidateCompressed(io.TeeReader(data, io.MultiWriter(&buf, cw)), mimeType, source.CompressionNone, 0)
+	if closeErr := cw.Close(); err == nil {
+		err = closeErr
+	}
+	if lw.N <= 0 && err != nil {
+		return nil, &payloadvalidator.Error{Kind: payloadvalidator.ErrTooLarge,
+			Detail: fmt.Sprintf("more than %d bytes once compressed with %s", maxBytes, c.compression)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// payloadSource describes the validated payload, a payload compressed while it is sent gets
+// the file name and mime type suffix of its compression
+func (c *Controller) payloadSource(id string, payload io.Reader, mimeType string) source.Source {
+	data := source.Source{
+		ID:               id,
+		Type:             mimeType,
+		Contents:         payload,
+		Compression:      c.compression,
+		CompressionLevel: c.compressionLevel,
+	}
+	if c.compression == source.CompressionZstd {
+		data.Type = strings.TrimSuffix(mimeType, "+tgz") + "+zstd"
+		data.Filename = "payload.tar.zst"
+	}
+	return data
+}
+
+func reportToLogs(source io.Reader, gzipped bool, klog klog.Verbose) error {
 	if !klog {
 		return nil
 	}
-	gr, err := gzip.NewReader(source)
-	if err != nil {
-		return err
+	if gzipped {
+		gr, err := gzip.NewReader(source)
+		if err != nil {
+			return err
+		}
+		source = gr
 	}
-	tr := tar.NewReader(gr)
+	tr := tar.NewReader(source)
 	for {
 		hdr, err := tr.Next()
 		if err != nil {
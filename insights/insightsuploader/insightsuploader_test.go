@@ -0,0 +1,303 @@
+package insightsuploader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/redhatinsights/insights-ingress-http-client/config"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/proxycontrol"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/redaction"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/uploadmetadata"
+)
+
+const mimeType = "application/vnd.redhat.openshift.periodic+tgz"
+
+func tarball(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(contents)), Mode: 0640}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadCompression(tt *testing.T) {
+	random := make([]byte, 64*1024)
+	_, _ = rand.Read(random)
+	payload := map[string][]byte{"config/log": bytes.Repeat([]byte("connected to 10.0.0.1\n"), 1024)}
+	testCases := []struct {
+		Name        string
+		Compression source.Compression
+		Data        []byte
+		MaxBytes    int64
+		Decompress  func(r io.Reader) (io.Reader, error)
+		Filename    string
+		Type        string
+		Reason      string
+	}{
+		{
+			Name: "Compressed payload",
+			Data: gzipped(tt, tarball(tt, payload)),
+			Decompress: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+			Filename: "payload.tar.gz",
+			Type:     mimeType,
+		},
+		{
+			Name:        "Raw payload compressed with gzip",
+			Compression: source.CompressionGzip,
+			Data:        tarball(tt, payload),
+			Decompress: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+			Filename: "payload.tar.gz",
+			Type:     mimeType,
+		},
+		{
+			Name:        "Raw payload compressed with zstd",
+			Compression: source.CompressionZstd,
+			Data:        tarball(tt, payload),
+			// the raw payload is larger than the limit, only the compressed size counts
+			MaxBytes: 4096,
+			Decompress: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+			Filename: "payload.tar.zst",
+			Type:     "application/vnd.redhat.openshift.periodic+zstd",
+		},
+		{
+			Name:        "Raw payload too large once compressed",
+			Compression: source.CompressionZstd,
+			Data:        tarball(tt, map[string][]byte{"config/random": random}),
+			MaxBytes:    4096,
+			Reason:      "InvalidPayload",
+		},
+		{
+			Name:        "Compressed payload sent as raw",
+			Compression: source.CompressionZstd,
+			Data:        gzipped(tt, tarball(tt, payload)),
+			Reason:      "InvalidPayload",
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			var received map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, hdr, err := r.FormFile("file")
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if hdr.Filename != tc.Filename || hdr.Header.Get("Content-Type") != tc.Type {
+					t.Errorf("unexpected file %s of type %s", hdr.Filename, hdr.Header.Get("Content-Type"))
+				}
+				dr, err := tc.Decompress(f)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				received = map[string]string{}
+				tr := tar.NewReader(dr)
+				for {
+					hdr, err := tr.Next()
+					if err != nil {
+						break
+					}
+					data, _ := ioutil.ReadAll(tr)
+					received[hdr.Name] = string(data)
+				}
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer srv.Close()
+
+			var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+			client := insightsclient.New(nil, tc.MaxBytes, "", "insightsuploader_test", &proxyCtrl, requestdecorator.New(nil, nil))
+			c := New(client, &config.SimpleConfigurator{Report: true, Endpoint: srv.URL})
+			c.SetCompression(tc.Compression, 0)
+			c.SetRedactor(redaction.New(redaction.NewIPRule()))
+			c.Upload(context.Background(), ioutil.NopCloser(bytes.NewReader(tc.Data)), mimeType)
+
+			summary, _ := c.CurrentStatus()
+			if tc.Reason != "" {
+				if summary.Reason != tc.Reason || received != nil {
+					t.Fatalf("expected the upload to fail with %s, got %+v", tc.Reason, summary)
+				}
+				return
+			}
+			if !summary.Healthy || c.LastSendResult() == nil {
+				t.Fatalf("unexpected status %+v", summary)
+			}
+			if received["config/log"] != string(bytes.Repeat([]byte("connected to 240.0.0.1\n"), 1024)) {
+				t.Fatalf("unexpected payload received %v", received)
+			}
+			if report := c.LastRedactionReport(); report == nil || report.Total() != 1024 {
+				t.Fatalf("unexpected redaction report %+v", report)
+			}
+		})
+	}
+}
+
+func TestUploadAtLimit(tt *testing.T) {
+	data := gzipped(tt, tarball(tt, map[string][]byte{"config/id": []byte("cluster")}))
+	testCases := []struct {
+		Name     string
+		MaxBytes int64
+		Reason   string
+	}{
+		{Name: "Exactly the limit", MaxBytes: int64(len(data))},
+		{Name: "One byte over the limit", MaxBytes: int64(len(data)) - 1, Reason: "InvalidPayload"},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer srv.Close()
+
+			var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+			client := insightsclient.New(nil, tc.MaxBytes, "", "insightsuploader_test", &proxyCtrl, requestdecorator.New(nil, nil))
+			c := New(client, &config.SimpleConfigurator{Report: true, Endpoint: srv.URL})
+			c.Upload(context.Background(), ioutil.NopCloser(bytes.NewReader(data)), mimeType)
+
+			summary, _ := c.CurrentStatus()
+			if summary.Reason != tc.Reason || (tc.Reason == "") != (c.LastSendResult() != nil) {
+				t.Fatalf("unexpected status %+v", summary)
+			}
+		})
+	}
+}
+
+func TestUploadTracking(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(`{"data":[{"service":"ingress","status":"success"}]}`))
+			return
+		}
+		_, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("x-rh-insights-request-id", "request-1")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	client := insightsclient.New(nil, 0, "", "insightsuploader_test", &proxyCtrl, requestdecorator.New(nil, nil))
+	c := New(client, &config.SimpleConfigurator{Report: true, Endpoint: srv.URL})
+	defer c.Stop()
+	c.SetPayloadTracking(srv.URL+"/payloads", insightsclient.PollOptions{Interval: 10 * time.Millisecond})
+
+	// the upload context usually ends with Upload, the tracking goes on
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Upload(ctx, ioutil.NopCloser(bytes.NewReader(gzipped(t, tarball(t, map[string][]byte{"config/id": []byte("cluster")})))), mimeType)
+	cancel()
+	if c.LastSendResult() == nil {
+		t.Fatalf("expected the upload to be accepted")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.LastPayloadStatus() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("the payload was not tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := c.LastPayloadStatus(); status.State != insightsclient.PayloadSuccess {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestUploadMetadata(tt *testing.T) {
+	data := gzipped(tt, tarball(tt, map[string][]byte{"config/id": []byte("cluster")}))
+	metadata := uploadmetadata.New(nil, requestdecorator.BasicRequestConfig{ClusterID: "cluster-id"})
+	encoded, err := metadata.Encode()
+	if err != nil {
+		tt.Fatal(err)
+	}
+	testCases := []struct {
+		Name     string
+		MaxBytes int64
+		Reason   string
+	}{
+		{Name: "Payload and metadata within the limit", MaxBytes: int64(len(data) + len(encoded))},
+		// the payload alone fits, the metadata part counts against the same limit
+		{Name: "Payload and metadata over the limit", MaxBytes: int64(len(data) + len(encoded) - 1), Reason: "InvalidPayload"},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			var parts map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseMultipartForm(1024 * 1024); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				parts = map[string]string{}
+				for name, values := range r.MultipartForm.Value {
+					parts[name] = values[0]
+				}
+				for name, files := range r.MultipartForm.File {
+					parts[name] = files[0].Header.Get("Content-Type")
+				}
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer srv.Close()
+
+			var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+			client := insightsclient.New(nil, tc.MaxBytes, "", "insightsuploader_test", &proxyCtrl, requestdecorator.New(nil, nil))
+			c := New(client, &config.SimpleConfigurator{Report: true, Endpoint: srv.URL})
+			c.SetMetadataBuilder(metadata)
+			c.Upload(context.Background(), ioutil.NopCloser(bytes.NewReader(data)), mimeType)
+
+			summary, _ := c.CurrentStatus()
+			if tc.Reason != "" {
+				if summary.Reason != tc.Reason || parts != nil {
+					t.Fatalf("expected the upload to fail with %s, got %+v", tc.Reason, summary)
+				}
+				return
+			}
+			if !summary.Healthy {
+				t.Fatalf("unexpected status %+v", summary)
+			}
+			if parts[uploadmetadata.PartName] != string(encoded) || parts["file"] != mimeType {
+				t.Fatalf("unexpected parts %v", parts)
+			}
+		})
+	}
+}
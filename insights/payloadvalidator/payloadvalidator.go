@@ -0,0 +1,185 @@
+package payloadvalidator
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
+)
+
+var (
+	// ErrInvalidMimeType An error for a mime type not following application/vnd.redhat.<service>.<category>+tgz
+	ErrInvalidMimeType = errors.New("invalid payload mime type")
+	// ErrInvalidGzip An error for a payload that is not a valid gzip stream
+	ErrInvalidGzip = errors.New("invalid gzip stream")
+	// ErrInvalidZstd An error for a payload that is not a valid zstd stream
+	ErrInvalidZstd = errors.New("invalid zstd stream")
+	// ErrInvalidTar An error for a payload that is not a valid tar archive
+	ErrInvalidTar = errors.New("invalid tar archive")
+	// ErrTooLarge An error for a payload over the byte limit
+	ErrTooLarge = errors.New("payload is too large")
+	// ErrUnsafePath An error for an archive entry with an absolute or parent relative path
+	ErrUnsafePath = errors.New("unsafe path in archive")
+	// ErrEmpty An error for an archive without any entries
+	ErrEmpty = errors.New("archive is empty")
+)
+
+var mimeTypePattern = regexp.MustCompile(`^application/vnd\.redhat\.[a-z0-9_-]+\.[a-z0-9_-]+\+tgz$`)
+
+// Error A validation failure, errors.Is matches it against the Err* values of this package
+type Error struct {
+	Kind   error
+	Detail string
+}
+
+// Error Obtains the error string from the error object
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Kind, e.Detail)
+}
+
+// Unwrap Returns the kind of the validation failure
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// IsValidationError Based on the error type it returns true
+// if it is a payload validation error and false otherwise
+func IsValidationError(err error) bool {
+	var e *Error
+	return errors.As(err, &e)
+}
+
+// ValidateMimeType Checks the mime type follows the ingress convention
+func ValidateMimeType(mimeType string) error {
+	if !mimeTypePattern.MatchString(mimeType) {
+		return &Error{Kind: ErrInvalidMimeType, Detail: fmt.Sprintf("%q does not match application/vnd.redhat.<service>.<category>+tgz", mimeType)}
+	}
+	return nil
+}
+
+// Validate Reads the whole payload and checks it is a well formed tar.gz archive
+// of at most maxBytes with only relative paths, a zero maxBytes disables the size check
+func Validate(data io.Reader, mimeType string, maxBytes int64) error {
+	return ValidateCompressed(data, mimeType, source.CompressionGzip, maxBytes)
+}
+
+// ValidateCompressed Reads the whole payload and checks it is a well formed tar archive compressed
+// with the algorithm, CompressionNone for an uncompressed archive, of at most maxBytes with only
+// relative paths, a zero maxBytes disables the size check
+func ValidateCompressed(data io.Reader, mimeType string, compression source.Compression, maxBytes int64) error {
+	if err := ValidateMimeType(mimeType); err != nil {
+		return err
+	}
+	counter := &countingReader{r: data}
+	var (
+		r          io.Reader
+		invalidErr error
+	)
+	switch compression {
+	case source.CompressionNone:
+		r = counter
+	case source.CompressionGzip:
+		gr, err := gzip.NewReader(counter)
+		if err != nil {
+			return sizeOr(counter, maxBytes, &Error{Kind: ErrInvalidGzip, Detail: err.Error()})
+		}
+		r, invalidErr = gr, ErrInvalidGzip
+	case source.CompressionZstd:
+		zr, err := zstd.NewReader(counter, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return &Error{Kind: ErrInvalidZstd, Detail: err.Error()}
+		}
+		defer zr.Close()
+		r, invalidErr = zr, ErrInvalidZstd
+	default:
+		return fmt.Errorf("unsupported compression %q", compression)
+	}
+	tr := tar.NewReader(r)
+	entries := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sizeOr(counter, maxBytes, &Error{Kind: ErrInvalidTar, Detail: err.Error()})
+		}
+		entries++
+		if err := validatePath(hdr.Name); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+			if err := validatePath(hdr.Linkname); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return sizeOr(counter, maxBytes, &Error{Kind: ErrInvalidTar, Detail: fmt.Sprintf("%s: %v", hdr.Name, err)})
+		}
+		if maxBytes > 0 && counter.n > maxBytes {
+			return tooLarge(counter, maxBytes)
+		}
+	}
+	// the checksum of the compressed stream is only verified once it is read to its end
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		kind := invalidErr
+		if kind == nil {
+			kind = ErrInvalidTar
+		}
+		return sizeOr(counter, maxBytes, &Error{Kind: kind, Detail: err.Error()})
+	}
+	if maxBytes > 0 && counter.n > maxBytes {
+		return tooLarge(counter, maxBytes)
+	}
+	if entries == 0 {
+		return &Error{Kind: ErrEmpty}
+	}
+	return nil
+}
+
+func validatePath(name string) error {
+	if strings.HasPrefix(name, "/") || path.IsAbs(name) {
+		return &Error{Kind: ErrUnsafePath, Detail: name}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return &Error{Kind: ErrUnsafePath, Detail: name}
+		}
+	}
+	return nil
+}
+
+// sizeOr prefers reporting an oversized payload over the error it caused downstream
+func sizeOr(counter *countingReader, maxBytes int64, err error) error {
+	if maxBytes > 0 && counter.n > maxBytes {
+		return tooLarge(counter, maxBytes)
+	}
+	return err
+}
+
+func tooLarge(counter *countingReader, maxBytes int64) error {
+	return &Error{Kind: ErrTooLarge, Detail: fmt.Sprintf("more than %d bytes (read %d)", maxBytes, counter.n)}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,185 @@
+package payloadvalidator
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
+)
+
+const mimeType = "application/vnd.redhat.openshift.periodic+tgz"
+
+type entry struct {
+	hdr      tar.Header
+	contents []byte
+}
+
+func file(name string, contents []byte) entry {
+	return entry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0640, Size: int64(len(contents))}, contents: contents}
+}
+
+func archive(t *testing.T, entries ...entry) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := e.hdr
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidateMimeType(tt *testing.T) {
+	testCases := []struct {
+		MimeType string
+		Valid    bool
+	}{
+		{MimeType: mimeType, Valid: true},
+		{MimeType: "application/vnd.redhat.advisor.collection+tgz", Valid: true},
+		{MimeType: "application/gzip"},
+		{MimeType: "application/vnd.redhat.openshift+tgz"},
+		{MimeType: "application/vnd.redhat.Openshift.periodic+tgz"},
+		{MimeType: "application/vnd.redhat.openshift.periodic+tgz; charset=utf-8"},
+		{MimeType: ""},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.MimeType, func(t *testing.T) {
+			err := ValidateMimeType(tc.MimeType)
+			if tc.Valid && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tc.Valid && !errors.Is(err, ErrInvalidMimeType) {
+				t.Fatalf("expected ErrInvalidMimeType, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidate(tt *testing.T) {
+	random := make([]byte, 64*1024)
+	_, _ = rand.Read(random)
+	valid := archive(tt, file("config/id", []byte("cluster")), file("config/version", []byte("4.7")))
+	corrupt := append([]byte{}, valid...)
+	// flip a byte of the gzip checksum
+	corrupt[len(corrupt)-5] ^= 0xff
+	large := archive(tt, file("config/random", random))
+	testCases := []struct {
+		Name     string
+		MimeType string
+		Data     []byte
+		MaxBytes int64
+		Err      error
+	}{
+		{Name: "Valid", Data: valid},
+		{Name: "Valid at the limit", Data: valid, MaxBytes: int64(len(valid))},
+		{Name: "Invalid mime type", MimeType: "application/gzip", Data: valid, Err: ErrInvalidMimeType},
+		{Name: "Not gzip", Data: []byte("plain text"), Err: ErrInvalidGzip},
+		{Name: "Corrupt checksum", Data: corrupt, Err: ErrInvalidGzip},
+		{Name: "Not tar", Data: gzipped(tt, []byte("plain text that is not a tar archive")), Err: ErrInvalidTar},
+		{Name: "Truncated", Data: valid[:len(valid)/2], Err: ErrInvalidTar},
+		{Name: "Empty", Data: archive(tt), Err: ErrEmpty},
+		{Name: "Over the limit", Data: valid, MaxBytes: int64(len(valid)) - 1, Err: ErrTooLarge},
+		{Name: "Truncated by the limit", Data: large, MaxBytes: 1024, Err: ErrTooLarge},
+		{Name: "Absolute path", Data: archive(tt, file("/etc/passwd", nil)), Err: ErrUnsafePath},
+		{Name: "Parent path", Data: archive(tt, file("config/../../etc/passwd", nil)), Err: ErrUnsafePath},
+		{
+			Name: "Unsafe symlink",
+			Data: archive(tt, entry{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "config/link", Linkname: "../../etc/passwd", Mode: 0777}}),
+			Err:  ErrUnsafePath,
+		},
+		{
+			Name: "Relative symlink",
+			Data: archive(tt, entry{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "config/link", Linkname: "config/id", Mode: 0777}}),
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			if tc.MimeType == "" {
+				tc.MimeType = mimeType
+			}
+			err := Validate(bytes.NewReader(tc.Data), tc.MimeType, tc.MaxBytes)
+			if tc.Err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.Err) || !IsValidationError(err) {
+				t.Fatalf("expected %v, got %v", tc.Err, err)
+			}
+		})
+	}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidateCompressed(tt *testing.T) {
+	var raw bytes.Buffer
+	tw := tar.NewWriter(&raw)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "config/id", Mode: 0640, Size: 7}); err != nil {
+		tt.Fatal(err)
+	}
+	_, _ = tw.Write([]byte("cluster"))
+	_ = tw.Close()
+	var zstdBuf bytes.Buffer
+	zw, _ := zstd.NewWriter(&zstdBuf)
+	_, _ = zw.Write(raw.Bytes())
+	_ = zw.Close()
+	testCases := []struct {
+		Name        string
+		Compression source.Compression
+		Data        []byte
+		Err         error
+	}{
+		{Name: "Raw tar", Compression: source.CompressionNone, Data: raw.Bytes()},
+		{Name: "Raw tar given gzip", Compression: source.CompressionNone, Data: gzipped(tt, raw.Bytes()), Err: ErrInvalidTar},
+		{Name: "Zstd tar", Compression: source.CompressionZstd, Data: zstdBuf.Bytes()},
+		{Name: "Gzip given as zstd", Compression: source.CompressionZstd, Data: gzipped(tt, raw.Bytes()), Err: ErrInvalidTar},
+		{Name: "Zstd given as gzip", Compression: source.CompressionGzip, Data: zstdBuf.Bytes(), Err: ErrInvalidGzip},
+		{Name: "Gzip tar", Compression: source.CompressionGzip, Data: gzipped(tt, raw.Bytes())},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			err := ValidateCompressed(bytes.NewReader(tc.Data), mimeType, tc.Compression, 0)
+			if tc.Err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.Err) {
+				t.Fatalf("expected %v, got %v", tc.Err, err)
+			}
+		})
+	}
+}
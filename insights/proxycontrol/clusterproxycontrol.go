@@ -0,0 +1,183 @@
+package proxycontrol
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	clusterProxyName         = "cluster"
+	openShiftConfigNamespace = "openshift-config"
+	trustedCABundleKey       = "ca-bundle.crt"
+)
+
+var clusterSettings = proxySettings{httpProxy: "status.httpProxy", httpsProxy: "status.httpsProxy", noProxy: "status.noProxy"}
+
+// TrustedCAProvider An optional interface of a ProxyControl that supplies the CA pool trusted by the client
+type TrustedCAProvider interface {
+	// TrustedCABundle returns nil when the proxy does not need additional CAs
+	TrustedCABundle() *x509.CertPool
+}
+
+// ClusterProxyControl A ProxyControl following the cluster wide Proxy object and its trusted CA ConfigMap,
+// it falls back to the environment until the cluster configuration has been loaded
+type ClusterProxyControl struct {
+	configClient configclient.Interface
+	kubeClient   kubernetes.Interface
+	fallback     BasicProxyControl
+
+	lock     sync.RWMutex
+	synced   bool
+	config   *httpproxy.Config
+	proxy    func(*url.URL) (*url.URL, error)
+	caBundle *x509.CertPool
+}
+
+// NewClusterProxyControl Initialize a new cluster proxy control object, Run must be called to start watching
+func NewClusterProxyControl(configClient configclient.Interface, kubeClient kubernetes.Interface) *ClusterProxyControl {
+	return &ClusterProxyControl{
+		configClient: configClient,
+		kubeClient:   kubeClient,
+	}
+}
+
+// Run Watches the Proxy object and the trusted CA ConfigMap until the context is done
+func (c *ClusterProxyControl) Run(ctx context.Context) error {
+	configInformers := configinformers.NewSharedInformerFactoryWithOptions(c.configClient, 0,
+		configinformers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", clusterProxyName).String()
+		}))
+	kubeInformers := informers.NewSharedInformerFactoryWithOptions(c.kubeClient, 0,
+		informers.WithNamespace(openShiftConfigNamespace))
+
+	proxyInformer := configInformers.Config().V1().Proxies()
+	configMapInformer := kubeInformers.Core().V1().ConfigMaps()
+	proxies := proxyInformer.Lister()
+	configMaps := configMapInformer.Lister()
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.sync(proxies, configMaps) },
+		UpdateFunc: func(interface{}, interface{}) { c.sync(proxies, configMaps) },
+		DeleteFunc: func(interface{}) { c.sync(proxies, configMaps) },
+	}
+	proxyInformer.Informer().AddEventHandler(handler)
+	configMapInformer.Informer().AddEventHandler(handler)
+
+	configInformers.Start(ctx.Done())
+	kubeInformers.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), proxyInformer.Informer().HasSynced, configMapInformer.Informer().HasSynced) {
+		return fmt.Errorf("unable to sync the cluster proxy configuration")
+	}
+	c.sync(proxies, configMaps)
+	<-ctx.Done()
+	return nil
+}
+
+// sync rebuilds the proxy function and CA pool from the cached objects
+func (c *ClusterProxyControl) sync(proxies configlisters.ProxyLister, configMaps corelisters.ConfigMapLister) {
+	proxy, err := proxies.Get(clusterProxyName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Warningf("Unable to get the cluster proxy: %v", err)
+			return
+		}
+		proxy = &configv1.Proxy{}
+	}
+
+	var caBundle *x509.CertPool
+	if name := proxy.Spec.TrustedCA.Name; name != "" {
+		cm, err := configMaps.ConfigMaps(openShiftConfigNamespace).Get(name)
+		if err != nil {
+			klog.Warningf("Unable to get the proxy trusted CA %s/%s: %v", openShiftConfigNamespace, name, err)
+		} else if caBundle, err = newCertPool([]byte(cm.Data[trustedCABundleKey])); err != nil {
+			klog.Warningf("Unable to load the proxy trusted CA %s/%s: %v", openShiftConfigNamespace, name, err)
+		}
+	}
+
+	config := &httpproxy.Config{
+		HTTPProxy:  proxy.Status.HTTPProxy,
+		HTTPSProxy: proxy.Status.HTTPSProxy,
+		NoProxy:    proxy.Status.NoProxy,
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.config == nil || *c.config != *config {
+		klog.V(2).Infof("Cluster proxy changed: httpProxy=%q httpsProxy=%q noProxy=%q",
+			RedactProxy(config.HTTPProxy), RedactProxy(config.HTTPSProxy), config.NoProxy)
+	}
+	c.config = config
+	c.proxy = config.ProxyFunc()
+	c.caBundle = caBundle
+	c.synced = true
+}
+
+// NewSystemOrConfiguredProxy Used to setup request proxy, the returned function always uses the latest cluster proxy
+func (c *ClusterProxyControl) NewSystemOrConfiguredProxy() func(*http.Request) (*url.URL, error) {
+	fallback := c.fallback.NewSystemOrConfiguredProxy()
+	return func(req *http.Request) (*url.URL, error) {
+		c.lock.RLock()
+		synced, proxy := c.synced, c.proxy
+		c.lock.RUnlock()
+		if !synced {
+			return fallback(req)
+		}
+		return proxy(req.URL)
+	}
+}
+
+// TraceProxy Explains the proxy chosen from the cluster proxy
+func (c *ClusterProxyControl) TraceProxy(req *http.Request) (ProxyDecision, error) {
+	c.lock.RLock()
+	synced, config, proxy := c.synced, c.config, c.proxy
+	c.lock.RUnlock()
+	if !synced {
+		decision, err := c.fallback.TraceProxy(req)
+		decision.Reason = "the cluster proxy is not loaded yet, " + decision.Reason
+		return decision, err
+	}
+	proxyURL, err := proxy(req.URL)
+	if err != nil {
+		return ProxyDecision{}, err
+	}
+	return ProxyDecision{Proxy: proxyURL, Reason: explainProxyConfig(config, req.URL, clusterSettings)}, nil
+}
+
+// TrustedCABundle Returns the CA pool of the ConfigMap referenced by the cluster proxy
+func (c *ClusterProxyControl) TrustedCABundle() *x509.CertPool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.caBundle
+}
+
+// newCertPool returns the system pool extended with the PEM certificates
+func newCertPool(pem []byte) (*x509.CertPool, error) {
+	if len(pem) == 0 {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if ok := pool.AppendCertsFromPEM(pem); !ok {
+		return nil, fmt.Errorf("no certificates found in %s", trustedCABundleKey)
+	}
+	return pool, nil
+}
@@ -0,0 +1,265 @@
+package proxycontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertkrimen/otto"
+	"k8s.io/klog"
+)
+
+const (
+	// maxPACSize limits the size of a downloaded PAC file
+	maxPACSize = 1024 * 1024
+	// pacTimeout limits the time a single evaluation of the PAC script may take
+	pacTimeout = time.Second
+)
+
+// ErrPACTimeout An error for a PAC script that did not finish in time
+var ErrPACTimeout = errors.New("the PAC script did not finish in time")
+
+// PACProxyControl A ProxyControl choosing the proxy of each request by evaluating the FindProxyForURL
+// function of a proxy auto-config script. The first usable entry of the result is used, as the transport
+// can not fail over to the next one. The date and time functions of the PAC standard are not available.
+// A request whose evaluation times out uses the proxy of the environment.
+type PACProxyControl struct {
+	lock sync.Mutex
+	vm   *otto.Otto
+	// loaded a copy of the vm as the script left it, it replaces a vm stopped in the middle of an evaluation
+	loaded   *otto.Otto
+	fallback BasicProxyControl
+	// exposed for tests
+	lookupIP func(host string) ([]net.IP, error)
+	timeout  time.Duration
+}
+
+// NewPACProxyControl Initialize a new PAC proxy control object from the script
+func NewPACProxyControl(script string) (*PACProxyControl, error) {
+	p := &PACProxyControl{
+		vm:       otto.New(),
+		lookupIP: net.LookupIP,
+		timeout:  pacTimeout,
+	}
+	for name, fn := range map[string]interface{}{
+		"isPlainHostName":     isPlainHostName,
+		"dnsDomainIs":         dnsDomainIs,
+		"localHostOrDomainIs": localHostOrDomainIs,
+		"dnsDomainLevels":     dnsDomainLevels,
+		"shExpMatch":          shExpMatch,
+		"isResolvable":        func(host string) bool { return p.resolve(host) != "" },
+		"isInNet":             p.isInNet,
+		"dnsResolve":          p.dnsResolve,
+		"myIpAddress":         myIPAddress,
+	} {
+		if err := p.vm.Set(name, fn); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := evaluate(p.vm, p.timeout, func() (otto.Value, error) { return p.vm.Run(script) }); err != nil {
+		return nil, fmt.Errorf("unable to load the PAC script: %w", err)
+	}
+	if fn, err := p.vm.Get("FindProxyForURL"); err != nil || !fn.IsFunction() {
+		return nil, fmt.Errorf("the PAC script does not define FindProxyForURL")
+	}
+	p.loaded = p.vm.Copy()
+	return p, nil
+}
+
+// NewPACProxyControlFromURL Initialize a new PAC proxy control object from the script published at pacURL
+func NewPACProxyControlFromURL(ctx context.Context, client *http.Client, pacURL string) (*PACProxyControl, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", pacURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download the PAC file: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download the PAC file: %s", resp.Status)
+	}
+	script, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxPACSize + 1})
+	if err != nil {
+		return nil, fmt.Errorf("unable to download the PAC file: %v", err)
+	}
+	if len(script) > maxPACSize {
+		return nil, fmt.Errorf("the PAC file exceeds %d bytes", maxPACSize)
+	}
+	return NewPACProxyControl(string(script))
+}
+
+// NewSystemOrConfiguredProxy Used to setup request proxy
+func (p *PACProxyControl) NewSystemOrConfiguredProxy() func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		decision, err := p.TraceProxy(req)
+		return decision.Proxy, err
+	}
+}
+
+// TraceProxy Explains the proxy chosen by the PAC script
+func (p *PACProxyControl) TraceProxy(req *http.Request) (ProxyDecision, error) {
+	result, err := p.FindProxyForURL(req.URL)
+	if errors.Is(err, ErrPACTimeout) {
+		klog.Warningf("%v, using the proxy of the environment", err)
+		decision, err := p.fallback.TraceProxy(req)
+		decision.Reason = "the PAC script timed out, " + decision.Reason
+		return decision, err
+	}
+	if err != nil {
+		return ProxyDecision{}, err
+	}
+	proxy, err := parsePACResult(result)
+	if err != nil {
+		return ProxyDecision{}, err
+	}
+	return ProxyDecision{Proxy: proxy, Reason: fmt.Sprintf("the PAC script returned %q for %s", result, req.URL.Hostname())}, nil
+}
+
+// FindProxyForURL Returns the raw result of the PAC script for the URL, it fails with ErrPACTimeout
+// when the script runs for too long
+func (p *PACProxyControl) FindProxyForURL(u *url.URL) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	value, err := evaluate(p.vm, p.timeout, func() (otto.Value, error) {
+		return p.vm.Call("FindProxyForURL", nil, u.String(), u.Hostname())
+	})
+	if errors.Is(err, ErrPACTimeout) {
+		// the interrupted evaluation may have left the scope of the vm behind
+		p.vm = p.loaded.Copy()
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to evaluate the PAC script for %s: %w", u.Host, err)
+	}
+	return value.String(), nil
+}
+
+// evaluate runs fn on the vm and interrupts it with ErrPACTimeout once the timeout has passed
+func evaluate(vm *otto.Otto, timeout time.Duration, fn func() (otto.Value, error)) (value otto.Value, err error) {
+	interrupt := make(chan func(), 1)
+	vm.Interrupt = interrupt
+	timer := time.AfterFunc(timeout, func() {
+		interrupt <- func() {
+			panic(ErrPACTimeout)
+		}
+	})
+	defer func() {
+		timer.Stop()
+		if caught := recover(); caught != nil {
+			if caught != ErrPACTimeout {
+				panic(caught)
+			}
+			err = ErrPACTimeout
+		}
+	}()
+	return fn()
+}
+
+// parsePACResult converts the first supported entry of a result like "PROXY host:port; DIRECT"
+// to a proxy URL, DIRECT is a nil URL
+func parsePACResult(result string) (*url.URL, error) {
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		switch kind := strings.ToUpper(fields[0]); {
+		case kind == "DIRECT":
+			return nil, nil
+		case len(fields) != 2:
+			continue
+		case kind == "PROXY" || kind == "HTTP":
+			return &url.URL{Scheme: "http", Host: fields[1]}, nil
+		case kind == "HTTPS":
+			return &url.URL{Scheme: "https", Host: fields[1]}, nil
+		case kind == "SOCKS" || kind == "SOCKS5":
+			return &url.URL{Scheme: "socks5", Host: fields[1]}, nil
+		}
+	}
+	return nil, fmt.Errorf("no supported proxy in the PAC result %q", result)
+}
+
+func isPlainHostName(host string) bool {
+	return !strings.Contains(host, ".")
+}
+
+func dnsDomainIs(host, domain string) bool {
+	return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+}
+
+func localHostOrDomainIs(host, hostdom string) bool {
+	return strings.EqualFold(host, hostdom) ||
+		(isPlainHostName(host) && strings.HasPrefix(strings.ToLower(hostdom), strings.ToLower(host)+"."))
+}
+
+func dnsDomainLevels(host string) int {
+	return strings.Count(host, ".")
+}
+
+func shExpMatch(str, shexp string) bool {
+	pattern := regexp.QuoteMeta(shexp)
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	matched, _ := regexp.MatchString("^"+pattern+"$", str)
+	return matched
+}
+
+// resolve returns the first IPv4 address of the host, or an empty string
+func (p *PACProxyControl) resolve(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	ips, err := p.lookupIP(host)
+	if err != nil {
+		return ""
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.String()
+		}
+	}
+	return ""
+}
+
+func (p *PACProxyControl) dnsResolve(host string) otto.Value {
+	ip := p.resolve(host)
+	if ip == "" {
+		return otto.NullValue()
+	}
+	value, _ := otto.ToValue(ip)
+	return value
+}
+
+func (p *PACProxyControl) isInNet(host, pattern, mask string) bool {
+	ip := net.ParseIP(p.resolve(host)).To4()
+	network := net.ParseIP(pattern).To4()
+	m := net.ParseIP(mask).To4()
+	if ip == nil || network == nil || m == nil {
+		return false
+	}
+	ipMask := net.IPMask(m)
+	return ip.Mask(ipMask).Equal(network.Mask(ipMask))
+}
+
+// myIPAddress returns the address used to reach other hosts, it does not send any packets
+func myIPAddress() string {
+	conn, err := net.Dial("udp", "198.51.100.1:80")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
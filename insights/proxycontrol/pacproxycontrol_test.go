@@ -0,0 +1,178 @@
+package proxycontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPAC = `
+function FindProxyForURL(url, host) {
+	if (isPlainHostName(host) || dnsDomainIs(host, ".internal.test")) {
+		return "DIRECT";
+	}
+	if (isInNet(host, "10.0.0.0", "255.0.0.0")) {
+		return "SOCKS5 socks.corp:1080";
+	}
+	if (shExpMatch(url, "https://*.redhat.com/*")) {
+		return "HTTPS secure.corp:8443; DIRECT";
+	}
+	if (localHostOrDomainIs(host, "api.openshift.com")) {
+		return "QUIC ignored.corp:1; PROXY proxy.corp:3128";
+	}
+	return "%s";
+}
+`
+
+func TestPACProxy(tt *testing.T) {
+	p, err := NewPACProxyControl(fmt.Sprintf(testPAC, "PROXY default.corp:3128"))
+	if err != nil {
+		tt.Fatalf("unexpected err %s", err)
+	}
+	p.lookupIP = func(host string) ([]net.IP, error) {
+		if host == "registry.internal" {
+			return []net.IP{net.ParseIP("10.1.2.3")}, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+	testCases := []struct {
+		Name       string
+		RequestURL string
+		ProxyURL   string
+	}{
+		{
+			Name:       "Plain host name is direct",
+			RequestURL: "http://registry/v2",
+			ProxyURL:   "",
+		},
+		{
+			Name:       "Domain is direct",
+			RequestURL: "http://a.internal.test/",
+			ProxyURL:   "",
+		},
+		{
+			Name:       "Resolved host in network uses SOCKS5",
+			RequestURL: "http://registry.internal/",
+			ProxyURL:   "socks5://socks.corp:1080",
+		},
+		{
+			Name:       "Unresolved host uses the default",
+			RequestURL: "http://registry.internal.corp/",
+			ProxyURL:   "http://default.corp:3128",
+		},
+		{
+			Name:       "IP in network uses SOCKS5",
+			RequestURL: "https://10.0.0.1/",
+			ProxyURL:   "socks5://socks.corp:1080",
+		},
+		{
+			Name:       "Shell expression uses the HTTPS proxy",
+			RequestURL: "https://console.redhat.com/api/ingress/v1/upload",
+			ProxyURL:   "https://secure.corp:8443",
+		},
+		{
+			Name:       "Unsupported entries are skipped",
+			RequestURL: "https://api.openshift.com/api",
+			ProxyURL:   "http://proxy.corp:3128",
+		},
+	}
+	proxy := p.NewSystemOrConfiguredProxy()
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			url, err := proxy(httptest.NewRequest("GET", tc.RequestURL, nil))
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			if (tc.ProxyURL == "" && url != nil) ||
+				(len(tc.ProxyURL) > 0 && (url == nil || tc.ProxyURL != url.String())) {
+				t.Fatalf("Unexpected value of Proxy Url. Test %s Expected Url %s Received Url %s", tc.Name, tc.ProxyURL, url)
+			}
+		})
+	}
+
+	decision, err := p.TraceProxy(httptest.NewRequest("GET", "https://api.openshift.com/api", nil))
+	if err != nil || decision.Reason != `the PAC script returned "QUIC ignored.corp:1; PROXY proxy.corp:3128" for api.openshift.com` {
+		tt.Fatalf("unexpected decision %s %v", decision, err)
+	}
+
+	for _, script := range []string{"function FindProxyForURL(url, host) {", "var proxy = 'DIRECT';"} {
+		if _, err := NewPACProxyControl(script); err == nil {
+			tt.Fatalf("expected an error for the script %q", script)
+		}
+	}
+}
+
+func TestPACProxyFromURL(t *testing.T) {
+	// a stand-in HTTP proxy answering every request itself
+	var proxied []string
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	defer proxySrv.Close()
+	pacSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		_, _ = fmt.Fprintf(w, testPAC, "PROXY "+proxySrv.Listener.Addr().String())
+	}))
+	defer pacSrv.Close()
+
+	p, err := NewPACProxyControlFromURL(context.Background(), nil, pacSrv.URL+"/proxy.pac")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: p.NewSystemOrConfiguredProxy()}}
+	resp, err := client.Get("http://console.redhat.test/api/ingress/v1/upload")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "proxied" || len(proxied) != 1 || !strings.HasSuffix(proxied[0], "/api/ingress/v1/upload") {
+		t.Fatalf("unexpected response %q through the proxy %v", body, proxied)
+	}
+}
+
+func TestPACProxyTimeout(t *testing.T) {
+	p, err := NewPACProxyControl(`
+function FindProxyForURL(url, host) {
+	while (host == "loop.test") {}
+	return "PROXY proxy.corp:3128";
+}
+`)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	p.timeout = 50 * time.Millisecond
+	p.fallback = BasicProxyControl{proxyFromEnvironment: func(*http.Request) (*url.URL, error) {
+		return url.Parse("http://fallback.to")
+	}}
+
+	if _, err := p.FindProxyForURL(&url.URL{Scheme: "https", Host: "loop.test"}); !errors.Is(err, ErrPACTimeout) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	decision, err := p.TraceProxy(httptest.NewRequest("GET", "https://loop.test/api", nil))
+	if err != nil || decision.Proxy.String() != "http://fallback.to" || !strings.HasPrefix(decision.Reason, "the PAC script timed out, ") {
+		t.Fatalf("expected the environment to be used, got %s %v", decision, err)
+	}
+
+	// the script keeps working for other hosts after it was interrupted
+	for i := 0; i < 2; i++ {
+		result, err := p.FindProxyForURL(&url.URL{Scheme: "https", Host: "console.redhat.com"})
+		if err != nil || result != "PROXY proxy.corp:3128" {
+			t.Fatalf("unexpected result %q %v", result, err)
+		}
+	}
+
+	if _, err := NewPACProxyControl("while (true) {}"); !errors.Is(err, ErrPACTimeout) {
+		t.Fatalf("expected loading the script to time out, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package proxycontrol
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	// DirectProxy The proxy of a rule connecting without any proxy
+	DirectProxy = "direct"
+)
+
+// ProxyConnectHeaderProvider An optional interface of a ProxyControl that supplies headers,
+// such as Proxy-Authorization, sent with the CONNECT request to the proxy chosen for a request
+type ProxyConnectHeaderProvider interface {
+	// ProxyConnectHeader returns nil when the CONNECT request needs no additional headers
+	ProxyConnectHeader(req *http.Request) http.Header
+}
+
+// ProxyRule Routes requests to hosts matching a glob, like "*.redhat.com", through a proxy
+type ProxyRule struct {
+	Host string
+	// Proxy the proxy URL, it may carry user:pass@ credentials, or DirectProxy
+	Proxy string
+	// Authorization the Proxy-Authorization header sent to the proxy of this rule only,
+	// for proxies that do not take user:pass@ credentials
+	Authorization string
+}
+
+type proxyRule struct {
+	host   string
+	proxy  *url.URL
+	header http.Header
+}
+
+// RuleProxyControl A ProxyControl choosing the proxy by the first rule matching the request host,
+// requests not matching any rule use the fallback
+type RuleProxyControl struct {
+	rules    []proxyRule
+	fallback ProxyControl
+}
+
+// NewRuleProxyControl Initialize a new rule based proxy control object, a nil fallback uses the environment
+func NewRuleProxyControl(rules []ProxyRule, fallback ProxyControl) (*RuleProxyControl, error) {
+	if fallback == nil {
+		fallback = BasicProxyControl{}
+	}
+	parsed := make([]proxyRule, 0, len(rules))
+	for _, rule := range rules {
+		host := strings.ToLower(rule.Host)
+		if _, err := path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("invalid proxy rule host %q: %v", rule.Host, err)
+		}
+		r := proxyRule{host: host}
+		if !strings.EqualFold(rule.Proxy, DirectProxy) {
+			proxy, err := parseProxyURL(rule.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy for rule %q: %v", rule.Host, err)
+			}
+			r.proxy = proxy
+		}
+		if rule.Authorization != "" {
+			if r.proxy == nil {
+				return nil, fmt.Errorf("proxy rule %q connects directly, it can not have a proxy authorization", rule.Host)
+			}
+			r.header = http.Header{"Proxy-Authorization": []string{rule.Authorization}}
+		}
+		parsed = append(parsed, r)
+	}
+	return &RuleProxyControl{
+		rules:    parsed,
+		fallback: fallback,
+	}, nil
+}
+
+// ProxyConnectHeader Returns the Proxy-Authorization of the rule matching the request,
+// requests using the fallback never get one
+func (r *RuleProxyControl) ProxyConnectHeader(req *http.Request) http.Header {
+	if rule := r.match(req.URL); rule != nil {
+		return rule.header
+	}
+	return nil
+}
+
+// NewSystemOrConfiguredProxy Used to setup request proxy
+func (r *RuleProxyControl) NewSystemOrConfiguredProxy() func(*http.Request) (*url.URL, error) {
+	fallback := r.fallback.NewSystemOrConfiguredProxy()
+	return func(req *http.Request) (*url.URL, error) {
+		if rule := r.match(req.URL); rule != nil {
+			return rule.proxy, nil
+		}
+		return fallback(req)
+	}
+}
+
+// TraceProxy Explains the proxy chosen by the rules
+func (r *RuleProxyControl) TraceProxy(req *http.Request) (ProxyDecision, error) {
+	if rule := r.match(req.URL); rule != nil {
+		return ProxyDecision{Proxy: rule.proxy, Reason: fmt.Sprintf("proxy rule %s matches %s", rule.host, req.URL.Hostname())}, nil
+	}
+	reason := fmt.Sprintf("no proxy rule matches %s", req.URL.Hostname())
+	if tracer, ok := r.fallback.(ProxyTracer); ok {
+		decision, err := tracer.TraceProxy(req)
+		decision.Reason = reason + ", " + decision.Reason
+		return decision, err
+	}
+	proxy, err := r.fallback.NewSystemOrConfiguredProxy()(req)
+	return ProxyDecision{Proxy: proxy, Reason: reason + ", using the fallback"}, err
+}
+
+// match returns the first rule matching the host of the URL
+func (r *RuleProxyControl) match(u *url.URL) *proxyRule {
+	host := strings.ToLower(u.Hostname())
+	for i := range r.rules {
+		if ok, _ := path.Match(r.rules[i].host, host); ok {
+			return &r.rules[i]
+		}
+	}
+	return nil
+}
+
+// String Describes the rules with the proxy credentials redacted
+func (r *RuleProxyControl) String() string {
+	rules := make([]string, 0, len(r.rules))
+	for _, rule := range r.rules {
+		proxy := DirectProxy
+		if rule.proxy != nil {
+			proxy = RedactURL(rule.proxy)
+		}
+		rules = append(rules, fmt.Sprintf("%s=%s", rule.host, proxy))
+	}
+	return strings.Join(rules, ",")
+}
+
+// parseProxyURL parses a proxy the way the environment proxy settings are parsed, the scheme defaults to http,
+// parse errors are not wrapped as they quote the URL along with its credentials
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		if u, err := url.Parse("http://" + proxy); err == nil && u.Host != "" {
+			return u, nil
+		}
+		return nil, fmt.Errorf("invalid proxy URL %q", RedactProxy(proxy))
+	}
+	return u, nil
+}
+
+// RedactURL Returns the URL with the password replaced, so it can be logged
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	if _, has := u.User.Password(); !has {
+		return u.String()
+	}
+	redacted := *u
+	redacted.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return redacted.String()
+}
+
+// RedactProxy Returns the proxy setting with the password replaced, so it can be logged
+func RedactProxy(proxy string) string {
+	if proxy == "" {
+		return ""
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		// the scheme is optional in proxy settings, without it the credentials are not parsed
+		if at := strings.LastIndex(proxy, "@"); at >= 0 {
+			return "xxxxx" + proxy[at:]
+		}
+		return proxy
+	}
+	return RedactURL(u)
+}
@@ -0,0 +1,95 @@
+package proxycontrol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+	"k8s.io/klog"
+)
+
+// ProxyDialer An optional interface of a ProxyControl that connects through the proxy itself,
+// the returned function replaces the DialContext of the client transport
+type ProxyDialer interface {
+	NewProxyDialer(forward *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// SOCKS5ProxyControl A ProxyControl dialing every connection through a SOCKS5 proxy,
+// except for the hosts matching the no proxy list
+type SOCKS5ProxyControl struct {
+	proxy      *url.URL
+	auth       *proxy.Auth
+	noProxy    string
+	bypassFunc func(*url.URL) (*url.URL, error)
+}
+
+// NewSOCKS5ProxyControl Initialize a new SOCKS5 proxy control object from a socks5://[user:pass@]host:port URL,
+// noProxy uses the NO_PROXY syntax
+func NewSOCKS5ProxyControl(proxyURL, noProxy string) (*SOCKS5ProxyControl, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid SOCKS5 proxy URL %q", RedactProxy(proxyURL))
+	}
+	if !strings.EqualFold(u.Scheme, "socks5") {
+		return nil, fmt.Errorf("unsupported SOCKS proxy scheme %q", u.Scheme)
+	}
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	// the proxy of the config is never used, it only tells whether an address bypasses the proxy
+	bypass := &httpproxy.Config{HTTPProxy: u.Host, HTTPSProxy: u.Host, NoProxy: noProxy}
+	return &SOCKS5ProxyControl{
+		proxy:      u,
+		auth:       auth,
+		noProxy:    noProxy,
+		bypassFunc: bypass.ProxyFunc(),
+	}, nil
+}
+
+// NewSystemOrConfiguredProxy Used to setup request proxy, the transport does not use an HTTP proxy
+// as the connections are dialed through the SOCKS5 proxy
+func (s *SOCKS5ProxyControl) NewSystemOrConfiguredProxy() func(*http.Request) (*url.URL, error) {
+	return func(*http.Request) (*url.URL, error) {
+		return nil, nil
+	}
+}
+
+// NewProxyDialer Returns a dial function connecting through the SOCKS5 proxy, the forward dialer
+// is used to reach the proxy and the hosts bypassing it
+func (s *SOCKS5ProxyControl) NewProxyDialer(forward *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer, err := proxy.SOCKS5("tcp", s.proxy.Host, s.auth, forward)
+	if err != nil {
+		return func(context.Context, string, string) (net.Conn, error) {
+			return nil, err
+		}
+	}
+	socks := dialer.(proxy.ContextDialer)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if via, err := s.bypassFunc(&url.URL{Scheme: "https", Host: addr}); err == nil && via == nil {
+			klog.V(5).Infof("Connecting to %s directly, it bypasses the SOCKS5 proxy", addr)
+			return forward.DialContext(ctx, network, addr)
+		}
+		klog.V(5).Infof("Connecting to %s through %s", addr, s)
+		return socks.DialContext(ctx, network, addr)
+	}
+}
+
+// TraceProxy Explains whether the connections of the request are dialed through the SOCKS5 proxy
+func (s *SOCKS5ProxyControl) TraceProxy(req *http.Request) (ProxyDecision, error) {
+	if reason := explainNoProxy(s.noProxy, req.URL, "NO_PROXY"); reason != "" {
+		return ProxyDecision{Reason: reason}, nil
+	}
+	return ProxyDecision{Proxy: s.proxy, Reason: fmt.Sprintf("no NO_PROXY entry matches %s", req.URL.Hostname())}, nil
+}
+
+// String Describes the proxy with its credentials redacted
+func (s *SOCKS5ProxyControl) String() string {
+	return RedactURL(s.proxy)
+}
@@ -0,0 +1,188 @@
+package proxycontrol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// socks5Server A stand-in SOCKS5 proxy connecting every request to target
+type socks5Server struct {
+	listener net.Listener
+	target   string
+	user     string
+	password string
+
+	lock      sync.Mutex
+	requested []string
+}
+
+func newSOCKS5Server(t *testing.T, target, user, password string) *socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	s := &socks5Server{listener: l, target: target, user: user, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+	// greeting: version, methods
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	if s.user == "" {
+		_, _ = conn.Write([]byte{5, 0})
+	} else {
+		_, _ = conn.Write([]byte{5, 2})
+		// username/password negotiation
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, user); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		password := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, password); err != nil {
+			return
+		}
+		if string(user) != s.user || string(password) != s.password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+	// request: version, command, reserved, address type
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		name := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	s.lock.Lock()
+	s.requested = append(s.requested, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2])))))
+	s.lock.Unlock()
+
+	target, err := net.Dial("tcp", s.target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go func() { _, _ = io.Copy(target, conn) }()
+	_, _ = io.Copy(conn, target)
+}
+
+func TestSOCKS5Proxy(tt *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		Name      string
+		User      string
+		Password  string
+		NoProxy   string
+		Requested []string
+	}{
+		{
+			Name:      "Without authentication",
+			Requested: []string{"console.redhat.test:80"},
+		},
+		{
+			Name:      "With username and password",
+			User:      "user",
+			Password:  "secret",
+			Requested: []string{"console.redhat.test:80"},
+		},
+		{
+			Name:    "Host bypassing the proxy",
+			NoProxy: ".redhat.test",
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			socks := newSOCKS5Server(t, srv.Listener.Addr().String(), tc.User, tc.Password)
+			defer socks.listener.Close()
+
+			proxyURL := "socks5://" + socks.listener.Addr().String()
+			if tc.User != "" {
+				proxyURL = fmt.Sprintf("socks5://%s:%s@%s", tc.User, tc.Password, socks.listener.Addr())
+			}
+			s, err := NewSOCKS5ProxyControl(proxyURL, tc.NoProxy)
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:       s.NewSystemOrConfiguredProxy(),
+				DialContext: s.NewProxyDialer(&net.Dialer{}),
+			}}
+			resp, err := client.Get("http://console.redhat.test/")
+			if tc.NoProxy != "" {
+				// the host does not resolve when it is dialed directly
+				if err == nil || len(socks.requested) != 0 {
+					t.Fatalf("expected a direct connection, got %v %v", err, socks.requested)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "ok" {
+				t.Fatalf("unexpected body %q", body)
+			}
+			if fmt.Sprint(socks.requested) != fmt.Sprint(tc.Requested) {
+				t.Fatalf("unexpected SOCKS5 requests %v", socks.requested)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package proxycontrol
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyTracer An optional interface of a ProxyControl that explains why a proxy was or was not chosen
+type ProxyTracer interface {
+	TraceProxy(req *http.Request) (ProxyDecision, error)
+}
+
+// ProxyDecision The proxy chosen for a request and the reason
+type ProxyDecision struct {
+	// Proxy nil when the request is sent directly
+	Proxy *url.URL
+	// Reason the rule, NO_PROXY entry or setting that decided
+	Reason string
+}
+
+// String Describes the decision with the proxy credentials redacted
+func (d ProxyDecision) String() string {
+	if d.Proxy == nil {
+		return fmt.Sprintf("direct: %s", d.Reason)
+	}
+	return fmt.Sprintf("proxy %s: %s", RedactURL(d.Proxy), d.Reason)
+}
+
+// proxySettings names the settings of a proxy configuration in the decision reasons
+type proxySettings struct {
+	httpProxy  string
+	httpsProxy string
+	noProxy    string
+}
+
+var environmentSettings = proxySettings{httpProxy: "HTTP_PROXY", httpsProxy: "HTTPS_PROXY", noProxy: "NO_PROXY"}
+
+// TraceProxy Explains the proxy chosen from the environment
+func (b BasicProxyControl) TraceProxy(req *http.Request) (ProxyDecision, error) {
+	proxy, err := b.NewSystemOrConfiguredProxy()(req)
+	if err != nil {
+		return ProxyDecision{}, err
+	}
+	return ProxyDecision{Proxy: proxy, Reason: explainProxyConfig(httpproxy.FromEnvironment(), req.URL, environmentSettings)}, nil
+}
+
+// explainProxyConfig tells which setting of the config applies to the URL, following the matching
+// rules of httpproxy and the NO_PROXY CIDR handling of NewProxierWithNoProxyCIDR
+func explainProxyConfig(config *httpproxy.Config, u *url.URL, settings proxySettings) string {
+	var name, proxy string
+	switch u.Scheme {
+	case "https":
+		name, proxy = settings.httpsProxy, config.HTTPSProxy
+	case "http":
+		name, proxy = settings.httpProxy, config.HTTPProxy
+	default:
+		return fmt.Sprintf("scheme %q is never proxied", u.Scheme)
+	}
+	if proxy == "" {
+		return fmt.Sprintf("%s is not set for scheme %s", name, u.Scheme)
+	}
+	if reason := explainNoProxy(config.NoProxy, u, settings.noProxy); reason != "" {
+		return reason
+	}
+	return fmt.Sprintf("%s is set for scheme %s and no %s entry matches %s", name, u.Scheme, settings.noProxy, u.Hostname())
+}
+
+// explainNoProxy describes why the URL bypasses the proxy, an empty string if it does not
+func explainNoProxy(noProxy string, u *url.URL, name string) string {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return fmt.Sprintf("%s is never proxied", host)
+	}
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if reason := matchNoProxy(entry, host, port, ip); reason != "" {
+			return fmt.Sprintf("%s %s", name, reason)
+		}
+	}
+	return ""
+}
+
+// matchNoProxy describes how a NO_PROXY entry matches the host, an empty string if it does not
+func matchNoProxy(entry, host, port string, ip net.IP) string {
+	if entry == "" {
+		return ""
+	}
+	if entry == "*" {
+		return "entry * matches every host"
+	}
+	if _, cidr, err := net.ParseCIDR(entry); err == nil {
+		if ip != nil && cidr.Contains(ip) {
+			return fmt.Sprintf("CIDR %s contains %s", entry, host)
+		}
+		return ""
+	}
+	entryHost, entryPort, err := net.SplitHostPort(entry)
+	if err != nil {
+		entryHost, entryPort = entry, ""
+	}
+	entryHost = strings.TrimSuffix(strings.TrimPrefix(entryHost, "["), "]")
+	if entryHost == "" || (entryPort != "" && entryPort != port) {
+		return ""
+	}
+	if entryIP := net.ParseIP(entryHost); entryIP != nil {
+		if ip != nil && entryIP.Equal(ip) {
+			return fmt.Sprintf("entry %s matches %s", entry, host)
+		}
+		return ""
+	}
+	// foo.com matches foo.com and its subdomains, .foo.com and *.foo.com only the subdomains
+	domain := entryHost
+	if strings.HasPrefix(domain, "*.") {
+		domain = domain[1:]
+	}
+	if strings.HasSuffix(host, domain) && (domain[0] == '.' || host == domain || strings.HasSuffix(host, "."+domain)) {
+		return fmt.Sprintf("entry %s matches %s", entry, host)
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter A token bucket limiter for upload bandwidth and request rate,
+// a single Limiter can be shared by several clients to apply a process wide limit
+type Limiter struct {
+	bandwidth *rate.Limiter
+	requests  *rate.Limiter
+	burst     int
+}
+
+// New Initialize a new limiter object, a zero or negative value leaves that dimension unlimited
+func New(bytesPerSecond int64, requestsPerMinute int) *Limiter {
+	l := &Limiter{}
+	if bytesPerSecond > 0 {
+		// a burst of one second worth of data, every read waits for at most that much
+		l.burst = int(bytesPerSecond)
+		l.bandwidth = rate.NewLimiter(rate.Limit(bytesPerSecond), l.burst)
+	}
+	if requestsPerMinute > 0 {
+		l.requests = rate.NewLimiter(rate.Every(time.Minute/time.Duration(requestsPerMinute)), 1)
+	}
+	return l
+}
+
+// WaitRequest Blocks until another request is allowed or the context is done
+func (l *Limiter) WaitRequest(ctx context.Context) error {
+	if l == nil || l.requests == nil {
+		return nil
+	}
+	return l.requests.Wait(ctx)
+}
+
+// Reader Returns a reader of r throttled to the bandwidth of the limiter
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil || l.bandwidth == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiter: l}
+}
+
+// ReadCloser Returns a read closer of rc throttled to the bandwidth of the limiter
+func (l *Limiter) ReadCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if l == nil || l.bandwidth == nil {
+		return rc
+	}
+	return readCloser{Reader: l.Reader(ctx, rc), closer: rc}
+}
+
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.burst {
+		p = p[:r.limiter.burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.bandwidth.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.closer.Close()
+}
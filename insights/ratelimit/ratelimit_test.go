@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type closer struct {
+	*bytes.Reader
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestUnlimited(t *testing.T) {
+	data := bytes.NewReader([]byte("data"))
+	for _, l := range []*Limiter{nil, New(0, 0)} {
+		if err := l.WaitRequest(context.Background()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if r := l.Reader(context.Background(), data); r != data {
+			t.Fatalf("expected the reader to be returned as is")
+		}
+		rc := ioutil.NopCloser(data)
+		if r := l.ReadCloser(context.Background(), rc); r != rc {
+			t.Fatalf("expected the read closer to be returned as is")
+		}
+	}
+}
+
+func TestWaitRequest(t *testing.T) {
+	l := New(0, 1)
+	if err := l.WaitRequest(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.WaitRequest(ctx); err == nil {
+		t.Fatalf("expected the second request of the minute to wait past the deadline")
+	}
+}
+
+func TestReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1500)
+	rc := &closer{Reader: bytes.NewReader(data)}
+	r := New(10000, 0).ReadCloser(context.Background(), rc)
+	start := time.Now()
+	read, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("unexpected data read")
+	}
+	// the burst covers the first 10000 bytes, the other 5000 take half a second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("the reader was not throttled, it took %s", elapsed)
+	}
+	if err := r.Close(); err != nil || !rc.closed {
+		t.Fatalf("expected the underlying reader to be closed, got %v", err)
+	}
+}
+
+func TestReaderCanceled(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 3000)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	read, err := ioutil.ReadAll(New(10000, 0).Reader(ctx, bytes.NewReader(data)))
+	if err == nil || len(read) == len(data) {
+		t.Fatalf("expected the read to stop with the context, read %d bytes: %v", len(read), err)
+	}
+}
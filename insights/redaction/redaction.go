@@ -0,0 +1,177 @@
+package redaction
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"sync"
+)
+
+// Rule An interface for obfuscating the contents of a single payload file
+type Rule interface {
+	// Name identifies the rule in the redaction report
+	Name() string
+	// Redact returns the rewritten contents and the number of redacted values
+	Redact(filename string, data []byte) ([]byte, int)
+}
+
+// Finding The number of values a rule redacted in a file
+type Finding struct {
+	File  string
+	Rule  string
+	Count int
+}
+
+// Report A summary of what was redacted from a payload
+type Report struct {
+	Files    int
+	Findings []Finding
+}
+
+// Total Returns the number of redacted values across all files and rules
+func (r *Report) Total() int {
+	total := 0
+	for _, f := range r.Findings {
+		total += f.Count
+	}
+	return total
+}
+
+// Redactor Applies the registered rules to every file of a tar.gz payload
+type Redactor struct {
+	lock  sync.Mutex
+	rules []Rule
+}
+
+// New Initialize a new redactor object
+func New(rules ...Rule) *Redactor {
+	return &Redactor{
+		rules: rules,
+	}
+}
+
+// Register Adds a rule, rules are applied in registration order
+func (r *Redactor) Register(rule Rule) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rules = append(r.rules, rule)
+}
+
+func (r *Redactor) currentRules() []Rule {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	rules := make([]Rule, len(r.rules))
+	copy(rules, r.rules)
+	return rules
+}
+
+// Redact Reads a tar.gz payload from in and writes the redacted archive to out
+func (r *Redactor) Redact(in io.Reader, out io.Writer) (*Report, error) {
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return &Report{}, fmt.Errorf("unable to read payload: %v", err)
+	}
+	gw := gzip.NewWriter(out)
+	report, err := r.RedactTar(gr, gw)
+	if err != nil {
+		return report, err
+	}
+	return report, gw.Close()
+}
+
+// RedactTar Reads an uncompressed tar payload from in and writes the redacted archive to out
+func (r *Redactor) RedactTar(in io.Reader, out io.Writer) (*Report, error) {
+	rules := r.currentRules()
+	report := &Report{}
+
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("unable to read payload: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return report, err
+			}
+			continue
+		}
+		// a file has to be held in memory because its size is written before its contents
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return report, fmt.Errorf("unable to read %s: %v", hdr.Name, err)
+		}
+		report.Files++
+		for _, rule := range rules {
+			var n int
+			data, n = rule.Redact(hdr.Name, data)
+			if n > 0 {
+				report.Findings = append(report.Findings, Finding{File: hdr.Name, Rule: rule.Name(), Count: n})
+			}
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return report, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return report, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return report, err
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].File < report.Findings[j].File
+	})
+	return report, nil
+}
+
+// Stream Returns a reader of the redacted payload, the report is sent on the channel
+// once the payload has been fully read. The channel is closed without a report when
+// redaction failed or the reader was closed early, as the counts would be partial.
+func (r *Redactor) Stream(in io.Reader) (io.ReadCloser, <-chan *Report) {
+	return stream(in, r.Redact)
+}
+
+// StreamTar Returns a reader of the redacted uncompressed tar payload, the report is sent like with Stream
+func (r *Redactor) StreamTar(in io.Reader) (io.ReadCloser, <-chan *Report) {
+	return stream(in, r.RedactTar)
+}
+
+func stream(in io.Reader, redact func(io.Reader, io.Writer) (*Report, error)) (io.ReadCloser, <-chan *Report) {
+	pr, pw := io.Pipe()
+	reports := make(chan *Report, 1)
+	go func() {
+		defer close(reports)
+		report, err := redact(in, pw)
+		if err == nil {
+			reports <- report
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, reports
+}
+
+// matchFiles reports whether filename matches any of the glob patterns, no patterns match every file
+func matchFiles(patterns []string, filename string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, filename); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(filename)); ok {
+			return true
+		}
+	}
+	return false
+}
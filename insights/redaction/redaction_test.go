@@ -0,0 +1,300 @@
+package redaction
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestIPRule(tt *testing.T) {
+	testCases := []struct {
+		Name     string
+		Input    string
+		Expected string
+		Count    int
+	}{
+		{
+			Name:     "Cluster networks",
+			Input:    "node 10.0.0.1 pod 10.128.0.5 service 172.30.0.1 node 10.0.0.1",
+			Expected: "node 240.0.0.1 pod 240.0.0.2 service 240.0.0.3 node 240.0.0.1",
+			Count:    4,
+		},
+		{
+			Name:     "Loopback and unspecified",
+			Input:    "listen 127.0.0.1 0.0.0.0 ::1 ::",
+			Expected: "listen 127.0.0.1 0.0.0.0 ::1 ::",
+		},
+		{
+			Name:     "IPv6",
+			Input:    "pod fd01::5 host 2620:52:0:1::10",
+			Expected: "pod 2001:db8::1 host 2001:db8::2",
+			Count:    2,
+		},
+		{
+			Name:     "Not addresses",
+			Input:    "version 4.7.0 time 12:30:00 size 999.1.1.1 octet 10.0.0.256",
+			Expected: "version 4.7.0 time 12:30:00 size 999.1.1.1 octet 10.0.0.256",
+		},
+		{
+			Name:     "Version strings",
+			Input:    "version 4.10.3.1.el8 release 1.4.10.3.1 kernel v4.10.3.1 build 4.10.3.1_2",
+			Expected: "version 4.10.3.1.el8 release 1.4.10.3.1 kernel v4.10.3.1 build 4.10.3.1_2",
+		},
+		{
+			Name:     "Punctuation",
+			Input:    "connected to 10.0.0.1. retried (10.0.0.2), gave up on 10.0.0.3.",
+			Expected: "connected to 240.0.0.1. retried (240.0.0.2), gave up on 240.0.0.3.",
+			Count:    3,
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			r := NewIPRule()
+			out, count := r.Redact("config/network", []byte(tc.Input))
+			if string(out) != tc.Expected || count != tc.Count {
+				t.Fatalf("expected %q with %d findings, got %q with %d", tc.Expected, tc.Count, out, count)
+			}
+			// a second pass leaves the substitutes alone
+			again, count := r.Redact("config/network", out)
+			if !bytes.Equal(again, out) || count != 0 {
+				t.Fatalf("a second pass changed %q to %q with %d findings", out, again, count)
+			}
+		})
+	}
+}
+
+func TestIPRuleSubstitutes(t *testing.T) {
+	r := NewIPRule()
+	var originals []string
+	for i := 0; i < 300; i++ {
+		originals = append(originals, net.IPv4(10, 0, byte(i>>8), byte(i)).String())
+	}
+	out, count := r.Redact("config/network", []byte(strings.Join(originals, " ")))
+	if count != len(originals) {
+		t.Fatalf("expected %d findings, got %d", len(originals), count)
+	}
+	mapping := r.Mapping()
+	if len(mapping) != len(originals) {
+		t.Fatalf("expected only the originals in the mapping, got %d entries", len(mapping))
+	}
+	seen := map[string]string{}
+	for _, original := range originals {
+		s, ok := mapping[original]
+		if !ok {
+			t.Fatalf("%s is not mapped", original)
+		}
+		if s == original {
+			t.Fatalf("%s mapped to itself", original)
+		}
+		if other, ok := seen[s]; ok {
+			t.Fatalf("%s and %s share the substitute %s", original, other, s)
+		}
+		seen[s] = original
+		if _, ok := mapping[s]; ok {
+			t.Fatalf("substitute %s is mapped as an original", s)
+		}
+		if strings.Contains(" "+string(out)+" ", " "+original+" ") {
+			t.Fatalf("%s was sent unredacted", original)
+		}
+	}
+	// the mapping is stable across files
+	if out, _ := r.Redact("config/other", []byte(originals[7])); string(out) != mapping[originals[7]] {
+		t.Fatalf("expected %s, got %s", mapping[originals[7]], out)
+	}
+}
+
+func TestIPRuleFiles(t *testing.T) {
+	r := NewIPRule("config/node/*")
+	if out, count := r.Redact("config/pod/a", []byte("10.0.0.1")); string(out) != "10.0.0.1" || count != 0 {
+		t.Fatalf("rule applied to a file it is not limited to: %q", out)
+	}
+	if out, count := r.Redact("config/node/a", []byte("10.0.0.1")); string(out) != "240.0.0.1" || count != 1 {
+		t.Fatalf("rule not applied to a matching file: %q", out)
+	}
+}
+
+func TestRegexRule(t *testing.T) {
+	r := NewRegexRule("password", regexp.MustCompile(`password=\S+`), "password=xxxxxx", "*.env")
+	if out, count := r.Redact("config/app.env", []byte("user=a password=b x password=c")); string(out) != "user=a password=xxxxxx x password=xxxxxx" || count != 2 {
+		t.Fatalf("unexpected result %q with %d findings", out, count)
+	}
+	if out, count := r.Redact("config/app.json", []byte("password=b")); string(out) != "password=b" || count != 0 {
+		t.Fatalf("rule applied to a file it is not limited to: %q", out)
+	}
+}
+
+func TestJSONPathRule(tt *testing.T) {
+	testCases := []struct {
+		Name     string
+		Paths    []string
+		File     string
+		Input    string
+		Expected string
+		Count    int
+	}{
+		{
+			Name:     "Nested key",
+			Paths:    []string{"spec.token"},
+			File:     "config/a.json",
+			Input:    `{"spec":{"token":"secret","name":"a"}}`,
+			Expected: `{"spec":{"name":"a","token":"xxxxxx"}}`,
+			Count:    1,
+		},
+		{
+			Name:     "Wildcards",
+			Paths:    []string{"items.*.data.*"},
+			File:     "config/a.json",
+			Input:    `{"items":[{"data":{"a":"1","b":"2"}},{"data":{"c":null}}]}`,
+			Expected: `{"items":[{"data":{"a":"xxxxxx","b":"xxxxxx"}},{"data":{"c":null}}]}`,
+			Count:    2,
+		},
+		{
+			Name:     "Missing path",
+			Paths:    []string{"spec.token"},
+			File:     "config/a.json",
+			Input:    `{"spec":{"name":"a"}}`,
+			Expected: `{"spec":{"name":"a"}}`,
+		},
+		{
+			Name:     "Not JSON",
+			Paths:    []string{"spec.token"},
+			File:     "config/a.json",
+			Input:    `spec.token: a`,
+			Expected: `spec.token: a`,
+		},
+		{
+			Name:     "Other files",
+			Paths:    []string{"token"},
+			File:     "config/a.yaml",
+			Input:    `{"token":"a"}`,
+			Expected: `{"token":"a"}`,
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			out, count := NewJSONPathRule(tc.Paths).Redact(tc.File, []byte(tc.Input))
+			if string(out) != tc.Expected || count != tc.Count {
+				t.Fatalf("expected %s with %d findings, got %s with %d", tc.Expected, tc.Count, out, count)
+			}
+		})
+	}
+}
+
+func TestDomainRule(t *testing.T) {
+	r := NewDomainRule("Example.Org")
+	out, count := r.Redact("config/a", []byte("api.EXAMPLE.org apps.example.org other.net"))
+	if count != 2 || strings.Contains(strings.ToLower(string(out)), "example.org") || !strings.HasSuffix(string(out), " other.net") {
+		t.Fatalf("unexpected result %q with %d findings", out, count)
+	}
+	if other, _ := NewDomainRule("example.org").Redact("config/b", []byte("example.org")); !bytes.Contains(out, other) {
+		t.Fatalf("the replacement is not derived from the base domain: %q and %q", out, other)
+	}
+}
+
+func writeArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(contents)), Mode: 0640}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestRedact(t *testing.T) {
+	in := writeArchive(t, map[string]string{
+		"config/node.json": `{"address":"10.0.0.1","token":"secret"}`,
+		"config/log":       "connected to 10.0.0.1",
+	})
+	r := New(NewIPRule())
+	r.Register(NewJSONPathRule([]string{"token"}))
+	out := &bytes.Buffer{}
+	report, err := r.Redact(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 2 || report.Total() != 3 || len(report.Findings) != 3 || report.Findings[0].File != "config/log" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	gr, err := gzip.NewReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		if int64(len(data)) != hdr.Size {
+			t.Fatalf("size of %s not updated", hdr.Name)
+		}
+		files[hdr.Name] = string(data)
+	}
+	if files["config/node.json"] != `{"address":"240.0.0.1","token":"xxxxxx"}` || files["config/log"] != "connected to 240.0.0.1" {
+		t.Fatalf("unexpected redacted files %v", files)
+	}
+}
+
+func TestRedactInvalid(t *testing.T) {
+	report, err := New(NewIPRule()).Redact(strings.NewReader("not a payload"), ioutil.Discard)
+	if err == nil {
+		t.Fatalf("expected an error for a payload that is not gzip")
+	}
+	if report == nil || report.Files != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestStream(t *testing.T) {
+	in := writeArchive(t, map[string]string{"config/log": "10.0.0.1"})
+	out, reports := New(NewIPRule()).Stream(in)
+	if _, err := ioutil.ReadAll(out); err != nil {
+		t.Fatal(err)
+	}
+	report := <-reports
+	if report == nil || report.Total() != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestStreamClosedEarly(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 64; i++ {
+		files[fmt.Sprintf("config/log%d", i)] = strings.Repeat(fmt.Sprintf("10.0.%d.1 ", i), 4096)
+	}
+	out, reports := New(NewIPRule()).Stream(writeArchive(t, files))
+	if _, err := out.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	if report, ok := <-reports; ok {
+		t.Fatalf("expected no report for a partially read payload, got %+v", report)
+	}
+}
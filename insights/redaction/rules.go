@@ -0,0 +1,326 @@
+package redaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// redactedValue The replacement for values removed by the JSON path rule
+	redactedValue = "xxxxxx"
+)
+
+// RegexRule Replaces every match of a regular expression
+type RegexRule struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement []byte
+	files       []string
+}
+
+// NewRegexRule Initialize a new regex rule object, files are glob patterns limiting the rule
+func NewRegexRule(name string, pattern *regexp.Regexp, replacement string, files ...string) *RegexRule {
+	return &RegexRule{
+		name:        name,
+		pattern:     pattern,
+		replacement: []byte(replacement),
+		files:       files,
+	}
+}
+
+// Name Returns the name of the rule
+func (r *RegexRule) Name() string {
+	return r.name
+}
+
+// Redact Replaces all matches of the pattern
+func (r *RegexRule) Redact(filename string, data []byte) ([]byte, int) {
+	if !matchFiles(r.files, filename) {
+		return data, 0
+	}
+	count := len(r.pattern.FindAllIndex(data, -1))
+	if count == 0 {
+		return data, 0
+	}
+	return r.pattern.ReplaceAll(data, r.replacement), count
+}
+
+// JSONPathRule Replaces the values found at dot separated paths in JSON files,
+// a "*" segment matches every key of an object or every element of an array
+type JSONPathRule struct {
+	paths [][]string
+	files []string
+}
+
+// NewJSONPathRule Initialize a new JSON path rule object, it applies to *.json files unless files are given
+func NewJSONPathRule(paths []string, files ...string) *JSONPathRule {
+	if len(files) == 0 {
+		files = []string{"*.json"}
+	}
+	split := make([][]string, 0, len(paths))
+	for _, p := range paths {
+		split = append(split, strings.Split(p, "."))
+	}
+	return &JSONPathRule{
+		paths: split,
+		files: files,
+	}
+}
+
+// Name Returns the name of the rule
+func (r *JSONPathRule) Name() string {
+	return "jsonpath"
+}
+
+// Redact Replaces the values at the configured paths, files that are not valid JSON are left untouched
+func (r *JSONPathRule) Redact(filename string, data []byte) ([]byte, int) {
+	if !matchFiles(r.files, filename) {
+		return data, 0
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data, 0
+	}
+	count := 0
+	for _, p := range r.paths {
+		doc, count = redactPath(doc, p, count)
+	}
+	if count == 0 {
+		return data, 0
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return data, 0
+	}
+	return out, count
+}
+
+func redactPath(node interface{}, segments []string, count int) (interface{}, int) {
+	if len(segments) == 0 {
+		if node == nil {
+			return node, count
+		}
+		return redactedValue, count + 1
+	}
+	seg, rest := segments[0], segments[1:]
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if seg == "*" || seg == k {
+				v[k], count = redactPath(child, rest, count)
+			}
+		}
+	case []interface{}:
+		if seg != "*" {
+			return node, count
+		}
+		for i, child := range v {
+			v[i], count = redactPath(child, rest, count)
+		}
+	}
+	return node, count
+}
+
+var (
+	// candidates are runs of dotted digits, replaceIPv4 only takes the ones that are a whole address
+	ipv4Pattern = regexp.MustCompile(`\d+(?:\.\d+)*`)
+	// candidates are loose, only the ones net.ParseIP accepts are replaced
+	ipv6Pattern = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?:::?[0-9A-Fa-f]{1,4}){1,7}(?:::)?`)
+
+	// substitutes are taken from reserved ranges that never hold the address of a cluster host,
+	// so they can not collide with the addresses being redacted
+	substitute4Net = &net.IPNet{IP: net.IPv4(240, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}
+	substitute6Net = &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}
+)
+
+// IPRule Replaces IPv4 and IPv6 addresses with stable substitutes,
+// the same address is always mapped to the same substitute across files
+type IPRule struct {
+	lock    sync.Mutex
+	mapping map[string]string
+	next4   uint32
+	next6   uint64
+	files   []string
+}
+
+// NewIPRule Initialize a new IP address rule object
+func NewIPRule(files ...string) *IPRule {
+	return &IPRule{
+		mapping: make(map[string]string),
+		files:   files,
+	}
+}
+
+// Name Returns the name of the rule
+func (r *IPRule) Name() string {
+	return "ip"
+}
+
+// Mapping Returns a copy of the original to substitute address mapping
+func (r *IPRule) Mapping() map[string]string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	mapping := make(map[string]string, len(r.mapping))
+	for k, v := range r.mapping {
+		mapping[k] = v
+	}
+	return mapping
+}
+
+// Redact Replaces every address that is not loopback or unspecified
+func (r *IPRule) Redact(filename string, data []byte) ([]byte, int) {
+	if !matchFiles(r.files, filename) {
+		return data, 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	count := 0
+	replace := func(m []byte) []byte {
+		ip := net.ParseIP(string(m))
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+			return m
+		}
+		// substitutes written by an earlier pass are already redacted
+		if substitute4Net.Contains(ip) || substitute6Net.Contains(ip) {
+			return m
+		}
+		s := r.substitute(ip)
+		count++
+		return []byte(s)
+	}
+	data = replaceIPv4(data, replace)
+	data = ipv6Pattern.ReplaceAllFunc(data, replace)
+	return data, count
+}
+
+// replaceIPv4 replaces the IPv4 addresses standing on their own. A run of dotted digits without exactly four
+// octets of at most 255, or next to a letter or a dot, like the version 4.10.3.1 in 4.10.3.1.el8, is left as it is.
+// A dot ending a sentence after the address does not count.
+func replaceIPv4(data []byte, replace func([]byte) []byte) []byte {
+	var out []byte
+	last := 0
+	for _, loc := range ipv4Pattern.FindAllIndex(data, -1) {
+		start, end := loc[0], loc[1]
+		if !isIPv4(data[start:end]) || (start > 0 && !ipv4Boundary(data[start-1])) {
+			continue
+		}
+		if end < len(data) && !ipv4Boundary(data[end]) &&
+			!(data[end] == '.' && (end+1 == len(data) || unicode.IsSpace(rune(data[end+1])))) {
+			continue
+		}
+		out = append(out, data[last:start]...)
+		out = append(out, replace(data[start:end])...)
+		last = end
+	}
+	if out == nil {
+		return data
+	}
+	return append(out, data[last:]...)
+}
+
+// isIPv4 returns true for four dot separated octets of at most 255
+func isIPv4(candidate []byte) bool {
+	octets := bytes.Split(candidate, []byte("."))
+	if len(octets) != net.IPv4len {
+		return false
+	}
+	for _, octet := range octets {
+		if len(octet) > 3 {
+			return false
+		}
+		if n, err := strconv.Atoi(string(octet)); err != nil || n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// ipv4Boundary returns true for the bytes that may surround an address
+func ipv4Boundary(b byte) bool {
+	return b != '.' && b != '_' && !('0' <= b && b <= '9') && !('a' <= b && b <= 'z') && !('A' <= b && b <= 'Z')
+}
+
+// substitute returns the mapped address, IPv4 addresses map into the reserved 240.0.0.0/8
+// and IPv6 addresses into the documentation prefix 2001:db8::/32
+func (r *IPRule) substitute(ip net.IP) string {
+	key := ip.String()
+	if s, ok := r.mapping[key]; ok {
+		return s
+	}
+	var sub net.IP
+	if v4 := ip.To4(); v4 != nil {
+		r.next4++
+		sub = make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(sub, binary.BigEndian.Uint32(substitute4Net.IP)+r.next4)
+	} else {
+		r.next6++
+		sub = make(net.IP, net.IPv6len)
+		copy(sub, substitute6Net.IP)
+		binary.BigEndian.PutUint64(sub[8:], r.next6)
+	}
+	s := sub.String()
+	r.mapping[key] = s
+	return s
+}
+
+// DomainRule Replaces the cluster base domain with an obfuscated one derived from it
+type DomainRule struct {
+	baseDomain  []byte
+	replacement []byte
+	files       []string
+}
+
+// NewDomainRule Initialize a new domain rule object keyed by the cluster base domain
+func NewDomainRule(baseDomain string, files ...string) *DomainRule {
+	baseDomain = strings.ToLower(baseDomain)
+	sum := sha256.Sum256([]byte(baseDomain))
+	return &DomainRule{
+		baseDomain:  []byte(baseDomain),
+		replacement: []byte("cluster-" + hex.EncodeToString(sum[:4]) + ".example.com"),
+		files:       files,
+	}
+}
+
+// Name Returns the name of the rule
+func (r *DomainRule) Name() string {
+	return "domain"
+}
+
+// Redact Replaces every case insensitive occurrence of the base domain
+func (r *DomainRule) Redact(filename string, data []byte) ([]byte, int) {
+	if len(r.baseDomain) == 0 || !matchFiles(r.files, filename) {
+		return data, 0
+	}
+	lower := bytes.ToLower(data)
+	if len(lower) != len(data) {
+		// non ASCII content changed length when lowered, fall back to an exact match
+		lower = data
+	}
+	count := 0
+	var out bytes.Buffer
+	for {
+		i := bytes.Index(lower, r.baseDomain)
+		if i < 0 {
+			break
+		}
+		count++
+		out.Write(data[:i])
+		out.Write(r.replacement)
+		data = data[i+len(r.baseDomain):]
+		lower = lower[i+len(r.baseDomain):]
+	}
+	if count == 0 {
+		return data, 0
+	}
+	out.Write(data)
+	return out.Bytes(), count
+}
@@ -0,0 +1,185 @@
+package remoteconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+
+	"github.com/redhatinsights/insights-ingress-http-client/controllerstatus"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+)
+
+const (
+	// SupportedMajorVersion The major version of the configuration document this client understands
+	SupportedMajorVersion = 1
+)
+
+// Condition A condition that has to hold for a gathering rule to apply
+type Condition struct {
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// GatheringRule Gathering functions to run, with their parameters, when all conditions hold
+type GatheringRule struct {
+	Conditions         []Condition                       `json:"conditions"`
+	GatheringFunctions map[string]map[string]interface{} `json:"gathering_functions"`
+}
+
+// Document The remote gathering configuration served by the service
+type Document struct {
+	Version                   string          `json:"version"`
+	ConditionalGatheringRules []GatheringRule `json:"conditional_gathering_rules"`
+}
+
+// Provider An interface for consulting the remote gathering configuration,
+// Summarizer implementations check it before building a payload
+type Provider interface {
+	// RemoteConfig returns the last good configuration and false if none was ever obtained
+	RemoteConfig() (*Document, bool)
+}
+
+// Parse Decodes a configuration document and verifies it against the expected schema. Minor versions
+// only add to the schema, so fields unknown to this client are ignored rather than rejected.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid remote configuration: %v", err)
+	}
+	if err := doc.validate(); err != nil {
+		return nil, fmt.Errorf("invalid remote configuration: %v", err)
+	}
+	return &doc, nil
+}
+
+func (d *Document) validate() error {
+	if d.Version == "" {
+		return fmt.Errorf("version is missing")
+	}
+	major, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(d.Version, "v"), ".", 2)[0])
+	if err != nil {
+		return fmt.Errorf("version %q is not a semantic version", d.Version)
+	}
+	if major != SupportedMajorVersion {
+		return fmt.Errorf("version %s is not supported, expected %d.x", d.Version, SupportedMajorVersion)
+	}
+	for i, rule := range d.ConditionalGatheringRules {
+		if len(rule.GatheringFunctions) == 0 {
+			return fmt.Errorf("rule %d has no gathering functions", i)
+		}
+		for name := range rule.GatheringFunctions {
+			if name == "" {
+				return fmt.Errorf("rule %d has a gathering function without a name", i)
+			}
+		}
+		for j, c := range rule.Conditions {
+			if c.Type == "" {
+				return fmt.Errorf("rule %d condition %d has no type", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// Fetcher Downloads the remote configuration and keeps the last good copy in memory and on disk
+type Fetcher struct {
+	controllerstatus.Simple
+
+	client    *insightsclient.Client
+	endpoint  string
+	cachePath string
+
+	lock    sync.Mutex
+	current *Document
+}
+
+// New Initialize a new remote configuration fetcher object, an empty cachePath disables the disk cache
+func New(client *insightsclient.Client, endpoint string, cachePath string) *Fetcher {
+	return &Fetcher{
+		Simple:    controllerstatus.Simple{Name: "remoteconfig"},
+		client:    client,
+		endpoint:  endpoint,
+		cachePath: cachePath,
+	}
+}
+
+// RemoteConfig Returns the last good configuration, loading it from the disk cache if nothing was downloaded yet
+func (f *Fetcher) RemoteConfig() (*Document, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.current == nil {
+		doc, err := f.loadCache()
+		if err != nil {
+			klog.V(2).Infof("Unable to load cached remote configuration: %v", err)
+		}
+		f.current = doc
+	}
+	return f.current, f.current != nil
+}
+
+// Refresh Downloads the configuration, a failed or invalid download keeps the last good copy
+func (f *Fetcher) Refresh(ctx context.Context) error {
+	data, _, err := f.client.Download(ctx, f.endpoint)
+	if err != nil {
+		f.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.RemoteConfiguration,
+			Reason: "RemoteConfigUnavailable", Message: fmt.Sprintf("Unable to download the remote configuration: %v", err)})
+		return err
+	}
+	doc, err := Parse(data)
+	if err != nil {
+		f.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.RemoteConfiguration,
+			Reason: "InvalidRemoteConfig", Message: err.Error()})
+		return err
+	}
+	if err := f.storeCache(data); err != nil {
+		klog.Warningf("Unable to cache the remote configuration: %v", err)
+	}
+	f.lock.Lock()
+	f.current = doc
+	f.lock.Unlock()
+	klog.V(4).Infof("Remote configuration version %s loaded with %d rules", doc.Version, len(doc.ConditionalGatheringRules))
+	f.Simple.UpdateStatus(controllerstatus.Summary{Healthy: true})
+	return nil
+}
+
+func (f *Fetcher) loadCache() (*Document, error) {
+	if f.cachePath == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(f.cachePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// storeCache replaces the cache file atomically so a crash never leaves a partial copy behind
+func (f *Fetcher) storeCache(data []byte) error {
+	if f.cachePath == "" {
+		return nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.cachePath), filepath.Base(f.cachePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.cachePath)
+}
@@ -0,0 +1,126 @@
+package remoteconfig
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/proxycontrol"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+)
+
+const validDocument = `{
+	"version": "1.0.0",
+	"conditional_gathering_rules": [
+		{
+			"conditions": [{"type": "alert_is_firing", "params": {"name": "ClusterVersionOperatorDown"}}],
+			"gathering_functions": {"logs_of_namespace": {"namespace": "openshift-cluster-version"}}
+		}
+	]
+}`
+
+func TestParse(tt *testing.T) {
+	testCases := []struct {
+		Name     string
+		Document string
+		Err      string
+	}{
+		{Name: "Valid", Document: validDocument},
+		{Name: "Prefixed version", Document: `{"version": "v1.2"}`},
+		{
+			Name:     "Newer minor version with new fields",
+			Document: `{"version": "1.3.0", "new_field": true, "conditional_gathering_rules": [{"new_rule_field": 1, "gathering_functions": {"f": {}}}]}`,
+		},
+		{Name: "Missing version", Document: `{"conditional_gathering_rules": []}`, Err: "version is missing"},
+		{Name: "Invalid version", Document: `{"version": "latest"}`, Err: "not a semantic version"},
+		{Name: "Unsupported major version", Document: `{"version": "2.0.0"}`, Err: "not supported"},
+		{Name: "Not JSON", Document: `version: 1.0.0`, Err: "invalid remote configuration"},
+		{Name: "Wrong type", Document: `{"version": "1.0.0", "conditional_gathering_rules": {}}`, Err: "invalid remote configuration"},
+		{
+			Name:     "Rule without functions",
+			Document: `{"version": "1.0.0", "conditional_gathering_rules": [{"conditions": []}]}`,
+			Err:      "rule 0 has no gathering functions",
+		},
+		{
+			Name:     "Function without a name",
+			Document: `{"version": "1.0.0", "conditional_gathering_rules": [{"gathering_functions": {"": {}}}]}`,
+			Err:      "without a name",
+		},
+		{
+			Name:     "Condition without a type",
+			Document: `{"version": "1.0.0", "conditional_gathering_rules": [{"conditions": [{}], "gathering_functions": {"f": {}}}]}`,
+			Err:      "rule 0 condition 0 has no type",
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			doc, err := Parse([]byte(tc.Document))
+			if tc.Err == "" {
+				if err != nil || doc == nil {
+					t.Fatalf("unexpected err %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.Err) {
+				t.Fatalf("expected an error containing %q, got %v", tc.Err, err)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	document := validDocument
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if document == "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(document))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "remoteconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "remoteconfig.json")
+
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	client := insightsclient.New(nil, 0, "", "remoteconfig_test", &proxyCtrl, requestdecorator.New(nil, nil))
+	f := New(client, srv.URL, cachePath)
+	if _, ok := f.RemoteConfig(); ok {
+		t.Fatalf("expected no configuration before the first refresh")
+	}
+	if err := f.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+	doc, ok := f.RemoteConfig()
+	if !ok || doc.Version != "1.0.0" || len(doc.ConditionalGatheringRules) != 1 {
+		t.Fatalf("unexpected configuration %+v", doc)
+	}
+
+	// invalid and failed downloads keep the last good copy
+	for _, d := range []string{`{"version": "2.0.0"}`, ""} {
+		document = d
+		if err := f.Refresh(context.Background()); err == nil {
+			t.Fatalf("expected an error for %q", d)
+		}
+		if current, ok := f.RemoteConfig(); !ok || current != doc {
+			t.Fatalf("the last good configuration was replaced by %+v", current)
+		}
+	}
+
+	// a new fetcher starts from the disk cache
+	cached, ok := New(client, srv.URL, cachePath).RemoteConfig()
+	if !ok || cached.Version != "1.0.0" {
+		t.Fatalf("unexpected cached configuration %+v", cached)
+	}
+}
@@ -0,0 +1,158 @@
+package chainauthorizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/basicauthauthorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/bearertokenauthorizer"
+)
+
+// Link An authorizer of the chain
+type Link struct {
+	// Name identifies the authorizer in the send result and metrics
+	Name       string
+	Authorizer requestauthorizer.ContextAuthorizer
+	// Available reports whether the credentials of the authorizer exist, nil if they always do,
+	// an authorizer can also return requestauthorizer.ErrNoCredentials
+	Available func() bool
+}
+
+// BasicAuthLink Returns a link authorizing with basic auth, it is skipped when the username is empty
+func BasicAuthLink(name string, username string, password string) Link {
+	return Link{
+		Name:       name,
+		Authorizer: requestauthorizer.Adapt(basicauthauthorizer.New(username, password)),
+		Available:  func() bool { return username != "" },
+	}
+}
+
+// BearerTokenLink Returns a link authorizing with a bearer token, it is skipped when the token is empty
+func BearerTokenLink(name string, token string) Link {
+	return Link{
+		Name:       name,
+		Authorizer: requestauthorizer.Adapt(bearertokenauthorizer.New(token)),
+		Available:  func() bool { return token != "" },
+	}
+}
+
+// ChainAuthorizer An authorizer trying a list of authorizers in order of priority, every request
+// uses the first one with credentials, so a higher priority source is used as soon as it appears
+type ChainAuthorizer struct {
+	links []Link
+
+	lock    sync.Mutex
+	current string
+}
+
+// New Initialize a new chain authorizer object
+func New(links ...Link) *ChainAuthorizer {
+	return &ChainAuthorizer{links: links}
+}
+
+// Authorize Authorizes the request with the first authorizer that succeeds, an authorizer failing for any reason
+// hands the request to the next one. It returns the error of the last failed authorizer, or an error wrapping
+// requestauthorizer.ErrNoCredentials when none had credentials.
+func (c *ChainAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	var (
+		skipped []string
+		lastErr error
+	)
+	for _, link := range c.links {
+		if link.Available != nil && !link.Available() {
+			skipped = append(skipped, link.Name)
+			continue
+		}
+		err := link.Authorizer.Authorize(ctx, req)
+		if errors.Is(err, requestauthorizer.ErrNoCredentials) {
+			skipped = append(skipped, link.Name)
+			continue
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			klog.V(2).Infof("Unable to authorize the request to %s with %s, trying the next authorizer: %v", req.URL.Host, link.Name, err)
+			lastErr = fmt.Errorf("%s: %w", link.Name, err)
+			continue
+		}
+		c.use(link.Name)
+		requestauthorizer.RecordAuthorizer(ctx, link.Name)
+		return nil
+	}
+	c.use("")
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("%w for %s", requestauthorizer.ErrNoCredentials, strings.Join(skipped, ", "))
+}
+
+// SetAuthorization Authorizes the request, the request is sent without authorization when no authorizer succeeded
+func (c *ChainAuthorizer) SetAuthorization(req *http.Request) {
+	if err := c.Authorize(req.Context(), req); err != nil {
+		klog.Errorf("Unable to authorize the request to %s: %v", req.URL.Host, err)
+	}
+}
+
+// InvalidateToken Drops the token of the authorizer of the last request, if it caches one. Concurrent requests
+// may have used another authorizer, InvalidateRequestToken drops the token of the rejected request.
+func (c *ChainAuthorizer) InvalidateToken() {
+	c.invalidate(c.Current(), nil)
+}
+
+// InvalidateRequestToken Drops the token of the authorizer recorded for the request, it returns false when that
+// authorizer caches no token. A request whose context does not come from requestauthorizer.WithAuthorizerRecord
+// is handled like InvalidateToken.
+func (c *ChainAuthorizer) InvalidateRequestToken(req *http.Request) bool {
+	name, ok := requestauthorizer.RecordedAuthorizer(req.Context())
+	if !ok {
+		name = c.Current()
+	}
+	return c.invalidate(name, req)
+}
+
+// invalidate drops the token of the named authorizer, it returns false when the authorizer caches no token
+func (c *ChainAuthorizer) invalidate(name string, req *http.Request) bool {
+	for _, link := range c.links {
+		if link.Name != name {
+			continue
+		}
+		if invalidator, ok := link.Authorizer.(requestauthorizer.RequestTokenInvalidator); ok && req != nil {
+			return invalidator.InvalidateRequestToken(req)
+		}
+		if invalidator, ok := link.Authorizer.(requestauthorizer.TokenInvalidator); ok {
+			invalidator.InvalidateToken()
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// Current Returns the name of the authorizer of the last request, empty when no authorizer had credentials
+func (c *ChainAuthorizer) Current() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.current
+}
+
+func (c *ChainAuthorizer) use(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.current == name {
+		return
+	}
+	if name == "" {
+		klog.V(2).Infof("No authorizer has credentials, %s is no longer used", c.current)
+	} else {
+		klog.V(2).Infof("Authorizing requests with %s", name)
+	}
+	c.current = name
+}
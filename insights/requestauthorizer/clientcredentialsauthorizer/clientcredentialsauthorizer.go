@@ -0,0 +1,90 @@
+package clientcredentialsauthorizer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/oauth2token"
+)
+
+const (
+	// DefaultTokenURL The token endpoint of the Red Hat SSO service accounts
+	DefaultTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+	// DefaultRefreshBefore How long before the expiry of a token a new one is requested
+	DefaultRefreshBefore = time.Minute
+)
+
+// Config The service account of the client credentials grant
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	// TokenURL defaults to DefaultTokenURL
+	TokenURL string
+	Scopes   []string
+	// RefreshBefore defaults to DefaultRefreshBefore
+	RefreshBefore time.Duration
+	// Client the client used to reach the token endpoint, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// ClientCredentialsAuthorizer An implementation of the OAuth2 client credentials grant
+// authorizing requests with a cached bearer access token
+type ClientCredentialsAuthorizer struct {
+	config Config
+	tokens *oauth2token.Source
+}
+
+// New Initialize a new client credentials authorizer object
+func New(config Config) *ClientCredentialsAuthorizer {
+	if config.TokenURL == "" {
+		config.TokenURL = DefaultTokenURL
+	}
+	if config.RefreshBefore == 0 {
+		config.RefreshBefore = DefaultRefreshBefore
+	}
+	a := &ClientCredentialsAuthorizer{config: config}
+	a.tokens = oauth2token.NewSource(a.fetch, config.RefreshBefore)
+	return a
+}
+
+// SetAuthorization Sets the authorization header to the access token, the request is sent
+// without authorization when no token could be obtained
+func (a *ClientCredentialsAuthorizer) SetAuthorization(req *http.Request) {
+	if err := a.Authorize(req.Context(), req); err != nil {
+		klog.Errorf("Unable to obtain an access token for %s: %v", a.config.ClientID, err)
+	}
+}
+
+// Authorize Sets the authorization header to the access token, obtaining a new one when needed
+func (a *ClientCredentialsAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	token, err := a.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	return nil
+}
+
+// InvalidateToken Drops the cached access token after it was rejected
+func (a *ClientCredentialsAuthorizer) InvalidateToken() {
+	a.tokens.Invalidate()
+}
+
+func (a *ClientCredentialsAuthorizer) fetch(ctx context.Context) (*oauth2token.Token, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {a.config.ClientID},
+		"client_secret": {a.config.ClientSecret},
+	}
+	if len(a.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.config.Scopes, " "))
+	}
+	klog.V(4).Infof("Requesting an access token for %s from %s", a.config.ClientID, a.config.TokenURL)
+	return oauth2token.Fetch(ctx, a.config.Client, a.config.TokenURL, form)
+}
@@ -0,0 +1,82 @@
+package clientcredentialsauthorizer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// tokenServer A stand-in token endpoint issuing numbered tokens
+func tokenServer(t *testing.T, expiresIn int, issued *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("unexpected err %s", err)
+		}
+		if r.PostForm.Get("grant_type") != "client_credentials" || r.PostForm.Get("client_id") != "svc" ||
+			r.PostForm.Get("client_secret") != "secret" || r.PostForm.Get("scope") != "api.console api.iam" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"unauthorized_client","error_description":"Invalid client secret"}`))
+			return
+		}
+		*issued++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, *issued, expiresIn)
+	}))
+}
+
+func TestSetAuthorization(tt *testing.T) {
+	testCases := []struct {
+		Name      string
+		ExpiresIn int
+		Secret    string
+		Expected  []string
+	}{
+		{
+			Name:      "Token is cached until it is about to expire",
+			ExpiresIn: 3600,
+			Secret:    "secret",
+			Expected:  []string{"Bearer token-1", "Bearer token-1", "Bearer token-2"},
+		},
+		{
+			Name:      "Token expiring within RefreshBefore is refreshed",
+			ExpiresIn: 30,
+			Secret:    "secret",
+			Expected:  []string{"Bearer token-1", "Bearer token-2", "Bearer token-3"},
+		},
+		{
+			Name:      "No authorization without a token",
+			ExpiresIn: 3600,
+			Secret:    "wrong",
+			Expected:  []string{"", "", ""},
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			issued := 0
+			srv := tokenServer(t, tc.ExpiresIn, &issued)
+			defer srv.Close()
+
+			a := New(Config{
+				ClientID:     "svc",
+				ClientSecret: tc.Secret,
+				TokenURL:     srv.URL,
+				Scopes:       []string{"api.console", "api.iam"},
+			})
+			var headers []string
+			for i := range tc.Expected {
+				if i == 2 {
+					// the last request follows a rejected token
+					a.InvalidateToken()
+				}
+				req := httptest.NewRequest("POST", "https://console.redhat.com/api/ingress/v1/upload", nil)
+				a.SetAuthorization(req)
+				headers = append(headers, req.Header.Get("Authorization"))
+			}
+			if fmt.Sprint(headers) != fmt.Sprint(tc.Expected) {
+				t.Fatalf("unexpected authorization %q, expected %q", headers, tc.Expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package identityauthorizer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	// IdentityHeader The header the Red Hat API gateway sets to the identity of an authenticated request
+	IdentityHeader = "x-rh-identity"
+	// DefaultType The identity type of users
+	DefaultType = "User"
+	// DefaultAuthType The authentication of users with a username and password
+	DefaultAuthType = "basic-auth"
+)
+
+// Identity The identity a local service receives from the API gateway
+type Identity struct {
+	OrgID         string
+	AccountNumber string
+	// Type defaults to DefaultType
+	Type string
+	// AuthType defaults to DefaultAuthType
+	AuthType string
+}
+
+type identityHeader struct {
+	Identity identityHeaderIdentity `json:"identity"`
+}
+
+type identityHeaderIdentity struct {
+	AccountNumber string                 `json:"account_number,omitempty"`
+	OrgID         string                 `json:"org_id"`
+	Type          string                 `json:"type"`
+	AuthType      string                 `json:"auth_type"`
+	Internal      identityHeaderInternal `json:"internal"`
+}
+
+type identityHeaderInternal struct {
+	OrgID string `json:"org_id"`
+}
+
+// IdentityAuthorizer An implementation for services behind the API gateway, for example a local ingress,
+// setting the identity header instead of authenticating the request
+type IdentityAuthorizer struct {
+	header string
+}
+
+// New Initialize a new identity authorizer object
+func New(identity Identity) *IdentityAuthorizer {
+	if identity.Type == "" {
+		identity.Type = DefaultType
+	}
+	if identity.AuthType == "" {
+		identity.AuthType = DefaultAuthType
+	}
+	// marshalling a struct of strings does not fail
+	data, _ := json.Marshal(identityHeader{Identity: identityHeaderIdentity{
+		AccountNumber: identity.AccountNumber,
+		OrgID:         identity.OrgID,
+		Type:          identity.Type,
+		AuthType:      identity.AuthType,
+		Internal:      identityHeaderInternal{OrgID: identity.OrgID},
+	}})
+	return &IdentityAuthorizer{
+		header: base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+// SetAuthorization Sets the identity header
+func (i *IdentityAuthorizer) SetAuthorization(req *http.Request) {
+	req.Header.Set(IdentityHeader, i.header)
+}
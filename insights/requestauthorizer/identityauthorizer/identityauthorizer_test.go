@@ -0,0 +1,43 @@
+package identityauthorizer
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetAuthorization(tt *testing.T) {
+	testCases := []struct {
+		Name     string
+		Identity Identity
+		Expected string
+	}{
+		{
+			Name:     "Defaults",
+			Identity: Identity{OrgID: "12345", AccountNumber: "6789"},
+			Expected: `{"identity":{"account_number":"6789","org_id":"12345","type":"User","auth_type":"basic-auth","internal":{"org_id":"12345"}}}`,
+		},
+		{
+			Name:     "Without account number",
+			Identity: Identity{OrgID: "12345", Type: "System", AuthType: "cert-auth"},
+			Expected: `{"identity":{"org_id":"12345","type":"System","auth_type":"cert-auth","internal":{"org_id":"12345"}}}`,
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://localhost:3000/api/ingress/v1/upload", nil)
+			New(tc.Identity).SetAuthorization(req)
+			header, err := base64.StdEncoding.DecodeString(req.Header.Get(IdentityHeader))
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			if string(header) != tc.Expected {
+				t.Fatalf("unexpected identity %s, expected %s", header, tc.Expected)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Fatalf("unexpected authorization %q", req.Header.Get("Authorization"))
+			}
+		})
+	}
+}
@@ -1,8 +1,96 @@
 package requestauthorizer
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrNoCredentials Returned by an authorizer whose credentials are not available,
+// a chain of authorizers moves on to the next one
+var ErrNoCredentials = errors.New("no credentials available")
 
 // RequestAuthorizer An interface for handling the authorization request header
 type RequestAuthorizer interface {
 	SetAuthorization(req *http.Request)
 }
+
+// ContextAuthorizer An interface for authorizers that may block or fail, for example while obtaining a token,
+// a request that could not be authorized is not sent
+type ContextAuthorizer interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
+
+// TokenInvalidator An optional interface of a RequestAuthorizer caching a token, the client drops
+// the token when a request is rejected as unauthorized and retries the request once
+type TokenInvalidator interface {
+	InvalidateToken()
+}
+
+// RequestTokenInvalidator An optional interface of a TokenInvalidator that drops the token of the authorizer
+// recorded for the rejected request, for authorizers switching between several sources of credentials.
+// It returns false when that authorizer caches no token, the request is not retried then.
+type RequestTokenInvalidator interface {
+	InvalidateRequestToken(req *http.Request) bool
+}
+
+// Adapt Returns the ContextAuthorizer of a RequestAuthorizer, authorizers implementing
+// both interfaces are returned as they are
+func Adapt(authorizer RequestAuthorizer) ContextAuthorizer {
+	if contextAuthorizer, ok := authorizer.(ContextAuthorizer); ok {
+		return contextAuthorizer
+	}
+	return adapter{authorizer: authorizer}
+}
+
+type adapter struct {
+	authorizer RequestAuthorizer
+}
+
+// Authorize Sets the authorization unless the context is already done
+func (a adapter) Authorize(ctx context.Context, req *http.Request) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.authorizer.SetAuthorization(req)
+	return nil
+}
+
+type authorizerRecordKey struct{}
+
+type authorizerRecord struct {
+	lock sync.Mutex
+	name string
+}
+
+// WithAuthorizerRecord Returns a context recording the name of the authorizer of a request, and a function reading the name
+func WithAuthorizerRecord(ctx context.Context) (context.Context, func() string) {
+	record := &authorizerRecord{}
+	return context.WithValue(ctx, authorizerRecordKey{}, record), func() string {
+		record.lock.Lock()
+		defer record.lock.Unlock()
+		return record.name
+	}
+}
+
+// RecordedAuthorizer Returns the name recorded for the request of the context so far,
+// false when the context does not come from WithAuthorizerRecord
+func RecordedAuthorizer(ctx context.Context) (string, bool) {
+	record, ok := ctx.Value(authorizerRecordKey{}).(*authorizerRecord)
+	if !ok {
+		return "", false
+	}
+	record.lock.Lock()
+	defer record.lock.Unlock()
+	return record.name, true
+}
+
+// RecordAuthorizer Records the name of the authorizer of a request when the context comes from WithAuthorizerRecord
+func RecordAuthorizer(ctx context.Context, name string) {
+	if record, ok := ctx.Value(authorizerRecordKey{}).(*authorizerRecord); ok {
+		record.lock.Lock()
+		defer record.lock.Unlock()
+		record.name = name
+	}
+}
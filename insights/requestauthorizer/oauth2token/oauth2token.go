@@ -0,0 +1,139 @@
+package oauth2token
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxResponseBytes limits the size of a token endpoint response
+	maxResponseBytes = 1024 * 1024
+)
+
+// Token An access token obtained from an OAuth2 token endpoint
+type Token struct {
+	AccessToken string
+	TokenType   string
+	// RefreshToken the refresh token returned with the access token, empty if none was returned
+	RefreshToken string
+	// Expiry the zero time when the endpoint did not tell when the token expires
+	Expiry time.Time
+}
+
+// Error An error response of the token endpoint
+type Error struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+// Error Obtains the error string from the error object
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("token endpoint returned %d %s: %s", e.StatusCode, e.Code, e.Description)
+	}
+	return fmt.Sprintf("token endpoint returned %d %s", e.StatusCode, e.Code)
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	ErrorCode        string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Fetch Posts the grant form to the token endpoint and returns the issued token
+func Fetch(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (*Token, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request a token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the token response: %v", err)
+	}
+
+	var parsed tokenResponse
+	jsonErr := json.Unmarshal(body, &parsed)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{StatusCode: resp.StatusCode, Code: parsed.ErrorCode, Description: parsed.ErrorDescription}
+	}
+	if jsonErr != nil {
+		return nil, fmt.Errorf("unable to decode the token response: %v", jsonErr)
+	}
+	if parsed.AccessToken == "" {
+		return nil, fmt.Errorf("the token response does not contain an access token")
+	}
+	token := &Token{
+		AccessToken:  parsed.AccessToken,
+		TokenType:    parsed.TokenType,
+		RefreshToken: parsed.RefreshToken,
+	}
+	if parsed.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(parsed.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// Source Caches the token of a grant and fetches a new one shortly before it expires
+type Source struct {
+	fetch         func(ctx context.Context) (*Token, error)
+	refreshBefore time.Duration
+	// exposed for tests
+	now func() time.Time
+
+	lock  sync.Mutex
+	token *Token
+}
+
+// NewSource Initialize a new token source object, the token is fetched again refreshBefore it expires
+func NewSource(fetch func(ctx context.Context) (*Token, error), refreshBefore time.Duration) *Source {
+	return &Source{
+		fetch:         fetch,
+		refreshBefore: refreshBefore,
+		now:           time.Now,
+	}
+}
+
+// Token Returns the cached token, or fetches a new one when there is none or it is about to expire
+func (s *Source) Token(ctx context.Context) (*Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.token != nil && (s.token.Expiry.IsZero() || s.now().Add(s.refreshBefore).Before(s.token.Expiry)) {
+		return s.token, nil
+	}
+	token, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// Invalidate Drops the cached token, so the next call to Token fetches a new one
+func (s *Source) Invalidate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = nil
+}
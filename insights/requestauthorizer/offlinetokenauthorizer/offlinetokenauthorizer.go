@@ -0,0 +1,110 @@
+package offlinetokenauthorizer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer/oauth2token"
+)
+
+const (
+	// DefaultTokenURL The token endpoint exchanging the offline tokens of the Red Hat API
+	DefaultTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+	// DefaultClientID The client the offline tokens of console.redhat.com are issued to
+	DefaultClientID = "cloud-services"
+	// DefaultRefreshBefore How long before the expiry of an access token a new one is requested
+	DefaultRefreshBefore = time.Minute
+)
+
+// Config The offline token and the endpoint exchanging it
+type Config struct {
+	// OfflineToken the refresh or offline token, as obtained from https://access.redhat.com/management/api
+	OfflineToken string
+	// ClientID defaults to DefaultClientID
+	ClientID string
+	// TokenURL defaults to DefaultTokenURL
+	TokenURL string
+	// RefreshBefore defaults to DefaultRefreshBefore
+	RefreshBefore time.Duration
+	// Client the client used to reach the token endpoint, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// OfflineTokenAuthorizer An implementation exchanging an offline token for short lived access tokens,
+// the access tokens are kept in memory until shortly before they expire
+type OfflineTokenAuthorizer struct {
+	config Config
+	tokens *oauth2token.Source
+
+	lock         sync.Mutex
+	refreshToken string
+}
+
+// New Initialize a new offline token authorizer object
+func New(config Config) *OfflineTokenAuthorizer {
+	if config.ClientID == "" {
+		config.ClientID = DefaultClientID
+	}
+	if config.TokenURL == "" {
+		config.TokenURL = DefaultTokenURL
+	}
+	if config.RefreshBefore == 0 {
+		config.RefreshBefore = DefaultRefreshBefore
+	}
+	a := &OfflineTokenAuthorizer{
+		config:       config,
+		refreshToken: config.OfflineToken,
+	}
+	a.tokens = oauth2token.NewSource(a.exchange, config.RefreshBefore)
+	return a
+}
+
+// SetAuthorization Sets the authorization header to the access token, the request is sent
+// without authorization when the offline token could not be exchanged
+func (a *OfflineTokenAuthorizer) SetAuthorization(req *http.Request) {
+	if err := a.Authorize(req.Context(), req); err != nil {
+		klog.Errorf("Unable to exchange the offline token: %v", err)
+	}
+}
+
+// Authorize Sets the authorization header to the access token, obtaining a new one when needed
+func (a *OfflineTokenAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	token, err := a.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	return nil
+}
+
+// InvalidateToken Drops the cached access token after it was rejected
+func (a *OfflineTokenAuthorizer) InvalidateToken() {
+	a.tokens.Invalidate()
+}
+
+// exchange requests an access token with the refresh token grant, a refresh token returned
+// with the access token replaces the previous one as the server may rotate it
+func (a *OfflineTokenAuthorizer) exchange(ctx context.Context) (*oauth2token.Token, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {a.config.ClientID},
+		"refresh_token": {a.refreshToken},
+	}
+	klog.V(4).Infof("Exchanging the offline token at %s", a.config.TokenURL)
+	token, err := oauth2token.Fetch(ctx, a.config.Client, a.config.TokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != "" {
+		a.refreshToken = token.RefreshToken
+	}
+	return token, nil
+}
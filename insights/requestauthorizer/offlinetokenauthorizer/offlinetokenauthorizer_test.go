@@ -0,0 +1,60 @@
+package offlinetokenauthorizer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+)
+
+func TestSetAuthorization(t *testing.T) {
+	var refreshTokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("unexpected err %s", err)
+		}
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("client_id") != DefaultClientID {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+			return
+		}
+		refreshTokens = append(refreshTokens, r.PostForm.Get("refresh_token"))
+		if r.PostForm.Get("refresh_token") == "revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Offline user session not found"}`))
+			return
+		}
+		// the server rotates the refresh token with every exchange
+		n := len(refreshTokens)
+		_, _ = fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"offline-%d","expires_in":900}`, n, n)
+	}))
+	defer srv.Close()
+
+	var authorizer requestauthorizer.RequestAuthorizer = New(Config{OfflineToken: "offline-0", TokenURL: srv.URL})
+	decorator := requestdecorator.New(nil, &authorizer)
+	var headers []string
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			decorator.InvalidateToken()
+		}
+		req := httptest.NewRequest("POST", "https://console.redhat.com/api/ingress/v1/upload", nil)
+		decorator.UpdateHeaders(req, "")
+		headers = append(headers, req.Header.Get("Authorization"))
+	}
+	if fmt.Sprint(headers) != "[Bearer access-1 Bearer access-1 Bearer access-2]" {
+		t.Fatalf("unexpected authorization %q", headers)
+	}
+	if fmt.Sprint(refreshTokens) != "[offline-0 offline-1]" {
+		t.Fatalf("unexpected refresh tokens %q", refreshTokens)
+	}
+
+	revoked := New(Config{OfflineToken: "revoked", TokenURL: srv.URL})
+	req := httptest.NewRequest("POST", "https://console.redhat.com/api/ingress/v1/upload", nil)
+	revoked.SetAuthorization(req)
+	if req.Header.Get("Authorization") != "" {
+		t.Fatalf("unexpected authorization %q", req.Header.Get("Authorization"))
+	}
+}
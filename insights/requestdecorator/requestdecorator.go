@@ -1,9 +1,12 @@
 package requestdecorator
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
+	"k8s.io/klog"
+
 	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
 )
 
@@ -26,20 +29,39 @@ func (b BasicRequestConfig) GetUserAgent() string {
 
 // RequestDecorator An object for updating the headers of a request
 type RequestDecorator struct {
-	config     *RequestConfig
-	authorizer *requestauthorizer.RequestAuthorizer
+	config *RequestConfig
+	// requestAuthorizer is read for every request, like config, so the authorizer it points to can be swapped
+	requestAuthorizer *requestauthorizer.RequestAuthorizer
+	authorizer        requestauthorizer.ContextAuthorizer
 }
 
-// New Initialize a new request decorator object
+// New Initialize a new request decorator object, the authorizer the pointer refers to when a request
+// is decorated is used
 func New(config *RequestConfig, authorizer *requestauthorizer.RequestAuthorizer) *RequestDecorator {
+	return &RequestDecorator{
+		config:            config,
+		requestAuthorizer: authorizer,
+	}
+}
+
+// NewWithContextAuthorizer Initialize a new request decorator object with an authorizer that may fail
+func NewWithContextAuthorizer(config *RequestConfig, authorizer requestauthorizer.ContextAuthorizer) *RequestDecorator {
 	return &RequestDecorator{
 		config:     config,
 		authorizer: authorizer,
 	}
 }
 
-// UpdateHeaders Adds user agent and content type headers to a request
+// UpdateHeaders Adds user agent and content type headers to a request, the request is left
+// without authorization when the authorizer fails
 func (rd *RequestDecorator) UpdateHeaders(req *http.Request, contentType string) {
+	if err := rd.Decorate(req.Context(), req, contentType); err != nil {
+		klog.Errorf("Unable to authorize the request to %s: %v", req.URL.Host, err)
+	}
+}
+
+// Decorate Adds user agent, content type and authorization headers to a request
+func (rd *RequestDecorator) Decorate(ctx context.Context, req *http.Request, contentType string) error {
 	if contentType != "" {
 		req.Header.Set("Content-Type", contentType)
 	}
@@ -50,8 +72,52 @@ func (rd *RequestDecorator) UpdateHeaders(req *http.Request, contentType string)
 			req.Header.Set("User-Agent", useragent)
 		}
 	}
+	if authorizer := rd.currentAuthorizer(); authorizer != nil {
+		return authorizer.Authorize(ctx, req)
+	}
+	return nil
+}
+
+// InvalidateToken Drops the token cached by the authorizer, it returns false when the authorizer does not cache tokens
+func (rd *RequestDecorator) InvalidateToken() bool {
+	if rd == nil {
+		return false
+	}
+	invalidator, ok := rd.current().(requestauthorizer.TokenInvalidator)
+	if !ok {
+		return false
+	}
+	invalidator.InvalidateToken()
+	return true
+}
+
+// InvalidateRequestToken Drops the token the authorizer used for the rejected request, it returns false
+// when that authorizer does not cache tokens
+func (rd *RequestDecorator) InvalidateRequestToken(req *http.Request) bool {
+	if rd == nil {
+		return false
+	}
+	if invalidator, ok := rd.current().(requestauthorizer.RequestTokenInvalidator); ok {
+		return invalidator.InvalidateRequestToken(req)
+	}
+	return rd.InvalidateToken()
+}
+
+// current returns the authorizer the pointer given to New refers to now, or the ContextAuthorizer
+func (rd *RequestDecorator) current() interface{} {
+	if rd.requestAuthorizer != nil && *rd.requestAuthorizer != nil {
+		return *rd.requestAuthorizer
+	}
 	if rd.authorizer != nil {
-		authorizer := *(rd.authorizer)
-		authorizer.SetAuthorization(req)
+		return rd.authorizer
+	}
+	return nil
+}
+
+// currentAuthorizer returns the current authorizer as a ContextAuthorizer
+func (rd *RequestDecorator) currentAuthorizer() requestauthorizer.ContextAuthorizer {
+	if rd.requestAuthorizer != nil && *rd.requestAuthorizer != nil {
+		return requestauthorizer.Adapt(*rd.requestAuthorizer)
 	}
+	return rd.authorizer
 }
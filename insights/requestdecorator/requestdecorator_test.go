@@ -0,0 +1,74 @@
+package requestdecorator
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestauthorizer"
+)
+
+type tokenAuthorizer struct {
+	token       string
+	invalidated int
+}
+
+func (a *tokenAuthorizer) SetAuthorization(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+}
+
+func (a *tokenAuthorizer) InvalidateToken() {
+	a.invalidated++
+}
+
+type staticAuthorizer struct{}
+
+func (staticAuthorizer) SetAuthorization(req *http.Request) {
+	req.Header.Set("Authorization", "Basic static")
+}
+
+func TestDecorateSwappedAuthorizer(t *testing.T) {
+	var config RequestConfig = BasicRequestConfig{OperatorName: "operator", OperatorCommit: "abc", ClusterID: "cluster"}
+	first := &tokenAuthorizer{token: "first"}
+	var authorizer requestauthorizer.RequestAuthorizer = first
+	rd := New(&config, &authorizer)
+
+	decorate := func() *http.Request {
+		req, _ := http.NewRequest("GET", "https://cloud.redhat.com", nil)
+		if err := rd.Decorate(context.Background(), req, "application/json"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return req
+	}
+	if req := decorate(); req.Header.Get("Authorization") != "Bearer first" || req.Header.Get("User-Agent") != "operator/abc cluster/cluster" {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+	if !rd.InvalidateToken() || first.invalidated != 1 {
+		t.Fatalf("expected the token of the authorizer to be invalidated")
+	}
+
+	// swapping the authorizer behind the pointer applies to the next request, like a new config does
+	second := &tokenAuthorizer{token: "second"}
+	authorizer = second
+	config = BasicRequestConfig{OperatorName: "operator", OperatorCommit: "def", ClusterID: "cluster"}
+	if req := decorate(); req.Header.Get("Authorization") != "Bearer second" || req.Header.Get("User-Agent") != "operator/def cluster/cluster" {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+	if !rd.InvalidateToken() || second.invalidated != 1 || first.invalidated != 1 {
+		t.Fatalf("expected only the token of the current authorizer to be invalidated")
+	}
+
+	authorizer = staticAuthorizer{}
+	if req := decorate(); req.Header.Get("Authorization") != "Basic static" {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+	if rd.InvalidateToken() {
+		t.Fatalf("expected an authorizer without a token not to invalidate")
+	}
+
+	// a decorator without an authorizer leaves the request unauthorized
+	rd = New(nil, nil)
+	if req := decorate(); req.Header.Get("Authorization") != "" || rd.InvalidateToken() {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+}
@@ -0,0 +1,151 @@
+package requestsigner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+const (
+	// DigestHeader The RFC 3230 digest of the body
+	DigestHeader = "Digest"
+	// ContentDigestHeader The RFC 9530 digest of the body
+	ContentDigestHeader = "Content-Digest"
+	// SignatureHeader The HMAC-SHA256 of the body keyed by the shared secret
+	SignatureHeader = "X-Rh-Insights-Signature"
+)
+
+var (
+	// ErrMissingDigest The request does not carry a digest of its body
+	ErrMissingDigest = errors.New("request has no body digest")
+	// ErrDigestMismatch The body does not match its digest, it was truncated or modified
+	ErrDigestMismatch = errors.New("body does not match its digest")
+	// ErrSignatureMismatch The body does not match its signature
+	ErrSignatureMismatch = errors.New("body does not match its signature")
+)
+
+// Signer Adds the SHA-256 digest of the body, and an HMAC signature when a key is set, to requests
+type Signer struct {
+	key []byte
+}
+
+// New Initialize a new signer object, without a key only the digest is sent
+func New(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign Sets the digest and signature headers of a request with a replayable body, a streamed body
+// is sent chunked with the digest and signature in trailers computed while the body is read
+func (s *Signer) Sign(req *http.Request) error {
+	if s == nil || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		digest, mac := sha256.New(), s.newMAC()
+		if _, err := io.Copy(writers(digest, mac), body); err != nil {
+			return fmt.Errorf("unable to compute the body digest: %v", err)
+		}
+		setDigest(req.Header, digest, mac)
+		return nil
+	}
+
+	if req.Trailer == nil {
+		req.Trailer = http.Header{}
+	}
+	req.Trailer.Set(DigestHeader, "")
+	req.Trailer.Set(ContentDigestHeader, "")
+	if len(s.key) > 0 {
+		req.Trailer.Set(SignatureHeader, "")
+	}
+	// trailers are only sent with a chunked body
+	req.ContentLength = -1
+	req.Body = &signingBody{
+		body:    req.Body,
+		trailer: req.Trailer,
+		digest:  sha256.New(),
+		mac:     s.newMAC(),
+	}
+	return nil
+}
+
+func (s *Signer) newMAC() hash.Hash {
+	if len(s.key) == 0 {
+		return nil
+	}
+	return hmac.New(sha256.New, s.key)
+}
+
+// Verify Checks the body against the digest and signature of the request, the trailers of a streamed
+// request are only available once its body was read. Without a key the signature is not checked.
+func Verify(header http.Header, body []byte, key []byte) error {
+	sum := sha256.Sum256(body)
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+	digest, contentDigest := header.Get(DigestHeader), header.Get(ContentDigestHeader)
+	if digest == "" && contentDigest == "" {
+		return ErrMissingDigest
+	}
+	if (digest != "" && digest != "sha-256="+encoded) || (contentDigest != "" && contentDigest != "sha-256=:"+encoded+":") {
+		return ErrDigestMismatch
+	}
+	if len(key) == 0 {
+		return nil
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(body)
+	expected := "hmac-sha256=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(expected)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+func setDigest(header http.Header, digest hash.Hash, mac hash.Hash) {
+	encoded := base64.StdEncoding.EncodeToString(digest.Sum(nil))
+	header.Set(DigestHeader, "sha-256="+encoded)
+	header.Set(ContentDigestHeader, "sha-256=:"+encoded+":")
+	if mac != nil {
+		header.Set(SignatureHeader, "hmac-sha256="+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	}
+}
+
+func writers(digest hash.Hash, mac hash.Hash) io.Writer {
+	if mac == nil {
+		return digest
+	}
+	return io.MultiWriter(digest, mac)
+}
+
+// signingBody hashes the body as the transport reads it and fills in the trailers at the end
+type signingBody struct {
+	body    io.ReadCloser
+	trailer http.Header
+	digest  hash.Hash
+	mac     hash.Hash
+	done    bool
+}
+
+func (b *signingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		_, _ = writers(b.digest, b.mac).Write(p[:n])
+	}
+	if err == io.EOF && !b.done {
+		b.done = true
+		setDigest(b.trailer, b.digest, b.mac)
+	}
+	return n, err
+}
+
+func (b *signingBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,81 @@
+package requestsigner
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSign(tt *testing.T) {
+	body := bytes.Repeat([]byte("payload"), 1000)
+	testCases := []struct {
+		Name      string
+		Key       []byte
+		VerifyKey []byte
+		Streamed  bool
+		Expected  error
+	}{
+		{Name: "Streamed digest", Streamed: true},
+		{Name: "Streamed signature", Key: []byte("secret"), VerifyKey: []byte("secret"), Streamed: true},
+		{Name: "Buffered signature", Key: []byte("secret"), VerifyKey: []byte("secret")},
+		{Name: "Wrong key", Key: []byte("secret"), VerifyKey: []byte("other"), Streamed: true, Expected: ErrSignatureMismatch},
+		{Name: "Missing signature", VerifyKey: []byte("secret"), Streamed: true, Expected: ErrSignatureMismatch},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			var verifyErr error
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ := ioutil.ReadAll(r.Body)
+				header := r.Header
+				if tc.Streamed {
+					// the trailers are only known once the body was read
+					header = r.Trailer
+				}
+				verifyErr = Verify(header, received, tc.VerifyKey)
+			}))
+			defer srv.Close()
+
+			var reqBody io.Reader = bytes.NewReader(body)
+			if tc.Streamed {
+				// hide the type of the reader, so the request body can not be replayed
+				reqBody = ioutil.NopCloser(reqBody)
+			}
+			req, err := http.NewRequest("POST", srv.URL, reqBody)
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			if err := New(tc.Key).Sign(req); err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			resp.Body.Close()
+			if verifyErr != tc.Expected {
+				t.Fatalf("unexpected verification %v, expected %v", verifyErr, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	header := http.Header{}
+	if err := Verify(header, []byte("payload"), nil); err != ErrMissingDigest {
+		t.Fatalf("unexpected err %v", err)
+	}
+	req := httptest.NewRequest("POST", "https://console.redhat.com", strings.NewReader("payload"))
+	req.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(strings.NewReader("payload")), nil }
+	if err := New(nil).Sign(req); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	// a proxy truncating the body
+	if err := Verify(req.Header, []byte("pay"), nil); err != ErrDigestMismatch {
+		t.Fatalf("unexpected err %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package scacontroller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog"
+
+	"github.com/redhatinsights/insights-ingress-http-client/controllerstatus"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+)
+
+const (
+	// DefaultEndpoint The OCM API endpoint issuing certificates
+	DefaultEndpoint = "https://api.openshift.com/api/accounts_mgmt/v1/certificates"
+	// DefaultNamespace The namespace of the entitlement secret
+	DefaultNamespace = "openshift-config-managed"
+	// DefaultSecretName The name of the entitlement secret
+	DefaultSecretName = "etc-pki-entitlement"
+	// CertKey The secret data key of the certificate
+	CertKey = "entitlement.pem"
+	// KeyKey The secret data key of the private key
+	KeyKey = "entitlement-key.pem"
+)
+
+// TokenSource An interface for obtaining the pull secret token, implemented by pullsecretcollector.PullSecretCollector
+type TokenSource interface {
+	GetPullSecretToken() (string, error)
+}
+
+// Config The settings of the SCA certificate controller
+type Config struct {
+	Endpoint   string
+	Namespace  string
+	SecretName string
+	Arch       string
+	// RefreshBefore how long before the certificate expiry a new one is requested
+	RefreshBefore time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Endpoint == "" {
+		c.Endpoint = DefaultEndpoint
+	}
+	if c.Namespace == "" {
+		c.Namespace = DefaultNamespace
+	}
+	if c.SecretName == "" {
+		c.SecretName = DefaultSecretName
+	}
+	if c.Arch == "" {
+		c.Arch = "x86_64"
+	}
+	if c.RefreshBefore <= 0 {
+		c.RefreshBefore = 24 * time.Hour
+	}
+	return c
+}
+
+// Controller Keeps the SCA certificates of the cluster in a secret up to date
+type Controller struct {
+	controllerstatus.Simple
+
+	client  *insightsclient.Client
+	tokens  TokenSource
+	secrets corev1client.SecretsGetter
+	config  Config
+}
+
+// New Initialize a new SCA certificate controller object
+func New(client *insightsclient.Client, tokens TokenSource, secrets corev1client.SecretsGetter, config Config) *Controller {
+	return &Controller{
+		Simple:  controllerstatus.Simple{Name: "scacontroller"},
+		client:  client,
+		tokens:  tokens,
+		secrets: secrets,
+		config:  config.withDefaults(),
+	}
+}
+
+// Refresh Requests new certificates when the secret is missing, invalid or close to expiry
+// and returns when the certificates should be refreshed next
+func (c *Controller) Refresh(ctx context.Context) (time.Time, error) {
+	secret, err := c.secrets.Secrets(c.config.Namespace).Get(ctx, c.config.SecretName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return time.Time{}, c.fail("SecretUnavailable", fmt.Errorf("unable to get secret %s/%s: %v", c.config.Namespace, c.config.SecretName, err))
+	}
+	if err == nil {
+		notAfter, perr := insightsclient.ParseSCACertificates(secret.Data[CertKey], secret.Data[KeyKey])
+		if perr == nil && time.Until(notAfter) > c.config.RefreshBefore {
+			klog.V(4).Infof("SCA certificates are valid until %s", notAfter.Format(time.RFC3339))
+			c.Simple.UpdateStatus(controllerstatus.Summary{Healthy: true})
+			return notAfter.Add(-c.config.RefreshBefore), nil
+		}
+		if perr != nil {
+			klog.V(2).Infof("Replacing invalid SCA certificates: %v", perr)
+		}
+	} else {
+		secret = nil
+	}
+
+	token, err := c.tokens.GetPullSecretToken()
+	if err != nil {
+		return time.Time{}, c.fail("NotAuthorized", fmt.Errorf("unable to get the pull secret token: %v", err))
+	}
+	certs, err := c.client.RequestSCACertificates(ctx, c.config.Endpoint, token, c.config.Arch)
+	if err != nil {
+		return time.Time{}, c.fail("CertificatesUnavailable", fmt.Errorf("unable to obtain SCA certificates: %v", err))
+	}
+	if err := c.writeSecret(ctx, secret, certs); err != nil {
+		return time.Time{}, c.fail("SecretUnavailable", err)
+	}
+	klog.V(2).Infof("SCA certificates updated, valid until %s", certs.NotAfter.Format(time.RFC3339))
+	c.Simple.UpdateStatus(controllerstatus.Summary{Healthy: true})
+	return certs.NotAfter.Add(-c.config.RefreshBefore), nil
+}
+
+// Run Refreshes the certificates until the context is done, retrying failures after interval
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	for {
+		next, err := c.Refresh(ctx)
+		wait := interval
+		if err != nil {
+			klog.V(2).Infof("Unable to refresh SCA certificates: %v", err)
+		} else if until := time.Until(next); until < wait {
+			wait = until
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Controller) writeSecret(ctx context.Context, existing *corev1.Secret, certs *insightsclient.SCACertificates) error {
+	data := map[string][]byte{
+		CertKey: []byte(certs.Cert),
+		KeyKey:  []byte(certs.Key),
+	}
+	if existing == nil {
+		_, err := c.secrets.Secrets(c.config.Namespace).Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.config.SecretName,
+				Namespace: c.config.Namespace,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to create secret %s/%s: %v", c.config.Namespace, c.config.SecretName, err)
+		}
+		return nil
+	}
+	updated := existing.DeepCopy()
+	updated.Data = data
+	if _, err := c.secrets.Secrets(c.config.Namespace).Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to update secret %s/%s: %v", c.config.Namespace, c.config.SecretName, err)
+	}
+	return nil
+}
+
+func (c *Controller) fail(reason string, err error) error {
+	c.Simple.UpdateStatus(controllerstatus.Summary{Operation: controllerstatus.PullingSCACerts,
+		Reason: reason, Message: err.Error()})
+	return err
+}
@@ -0,0 +1,109 @@
+package scacontroller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/proxycontrol"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+)
+
+type staticToken string
+
+func (s staticToken) GetPullSecretToken() (string, error) {
+	return string(s), nil
+}
+
+func newCertificate(t *testing.T, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sca"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %v", err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(cert), string(keyPem)
+}
+
+func TestRefresh(t *testing.T) {
+	requests := 0
+	notAfter := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	cert, key := newCertificate(t, notAfter)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["type"] != "sca" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "1", "cert": cert, "key": key})
+	}))
+	defer srv.Close()
+
+	var proxyCtrl proxycontrol.ProxyControl = proxycontrol.BasicProxyControl{}
+	client := insightsclient.New(nil, 0, "", "scacontroller_test", &proxyCtrl, requestdecorator.New(nil, nil))
+	kube := fake.NewSimpleClientset()
+	c := New(client, staticToken("token"), kube.CoreV1(), Config{Endpoint: srv.URL})
+
+	// the first refresh creates the secret, the second one finds it valid and does nothing
+	for i := 0; i < 2; i++ {
+		next, err := c.Refresh(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+		if !next.Equal(notAfter.Add(-24 * time.Hour)) {
+			t.Fatalf("unexpected next refresh %s", next)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 certificate request, got %d", requests)
+	}
+	secret, err := kube.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), DefaultSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if string(secret.Data[CertKey]) != cert || string(secret.Data[KeyKey]) != key {
+		t.Fatalf("unexpected secret data %v", secret.Data)
+	}
+
+	// a certificate close to expiry is replaced
+	c.config.RefreshBefore = 60 * 24 * time.Hour
+	if _, err := c.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 certificate requests, got %d", requests)
+	}
+}
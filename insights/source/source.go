@@ -2,10 +2,56 @@ package source
 
 import "io"
 
+// Compression The algorithm used to compress a part while it is uploaded
+type Compression string
+
+const (
+	// CompressionNone the contents are sent as they are
+	CompressionNone Compression = ""
+	// CompressionGzip the contents are gzip compressed
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd the contents are zstd compressed
+	CompressionZstd Compression = "zstd"
+)
+
 // Source An object for storing data of multiple types
 type Source struct {
 	ID       string
 	Type     string
 	Filename string
 	Contents io.Reader
+	// Compression compresses raw Contents on the fly, the Type must describe the compressed data
+	Compression Compression
+	// CompressionLevel the algorithm specific level, zero selects the default
+	CompressionLevel int
+}
+
+// Part A single form field or file part of a multipart upload
+type Part struct {
+	// Name the form field name of the part
+	Name string
+	// Filename makes the part a file part, form fields leave it empty
+	Filename string
+	Type     string
+	Contents io.Reader
+	// Compression compresses raw Contents on the fly
+	Compression Compression
+	// CompressionLevel the algorithm specific level, zero selects the default
+	CompressionLevel int
+}
+
+// FilePart Returns the source as the "file" part of a multipart upload
+func (s Source) FilePart() Part {
+	filename := "payload.tar.gz"
+	if s.Filename != "" {
+		filename = s.Filename
+	}
+	return Part{
+		Name:             "file",
+		Filename:         filename,
+		Type:             s.Type,
+		Contents:         s.Contents,
+		Compression:      s.Compression,
+		CompressionLevel: s.CompressionLevel,
+	}
 }
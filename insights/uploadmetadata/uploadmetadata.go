@@ -0,0 +1,123 @@
+package uploadmetadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"k8s.io/klog"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/source"
+)
+
+const (
+	// PartName The form field name ingress reads the metadata from
+	PartName = "metadata"
+)
+
+// ClusterVersionGetter An interface for obtaining the cluster version, implemented by clusterversioncollector.ClusterVersionCollector
+type ClusterVersionGetter interface {
+	GetClusterVersion() (*configv1.ClusterVersion, error)
+}
+
+// Metadata The description of the cluster and sender attached to an upload
+type Metadata struct {
+	ClusterID      string            `json:"cluster_id,omitempty"`
+	ClusterVersion string            `json:"cluster_version,omitempty"`
+	OperatorName   string            `json:"operator_name,omitempty"`
+	OperatorCommit string            `json:"operator_commit,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// Builder Assembles the upload metadata
+type Builder struct {
+	cluster ClusterVersionGetter
+	config  requestdecorator.BasicRequestConfig
+
+	lock   sync.Mutex
+	labels map[string]string
+}
+
+// New Initialize a new metadata builder object, cluster may be nil when the version is not available
+func New(cluster ClusterVersionGetter, config requestdecorator.BasicRequestConfig) *Builder {
+	return &Builder{
+		cluster: cluster,
+		config:  config,
+		labels:  make(map[string]string),
+	}
+}
+
+// SetLabel Adds a caller defined label to the metadata, an empty value removes the label
+func (b *Builder) SetLabel(key, value string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if value == "" {
+		delete(b.labels, key)
+		return
+	}
+	b.labels[key] = value
+}
+
+// Build Returns the metadata, it fails with insightsclient.ErrObtainingForVersion or
+// insightsclient.ErrWaitingForVersion while the cluster version is not known
+func (b *Builder) Build() (*Metadata, error) {
+	m := &Metadata{
+		ClusterID:      b.config.ClusterID,
+		OperatorName:   b.config.OperatorName,
+		OperatorCommit: b.config.OperatorCommit,
+	}
+	if b.cluster != nil {
+		cv, err := b.cluster.GetClusterVersion()
+		if err != nil {
+			klog.V(4).Infof("Unable to get the cluster version: %v", err)
+			return nil, insightsclient.ErrObtainingForVersion
+		}
+		if cv.Status.Desired.Version == "" {
+			return nil, insightsclient.ErrWaitingForVersion
+		}
+		m.ClusterVersion = cv.Status.Desired.Version
+		if cv.Spec.ClusterID != "" {
+			m.ClusterID = string(cv.Spec.ClusterID)
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.labels) > 0 {
+		m.Labels = make(map[string]string, len(b.labels))
+		for k, v := range b.labels {
+			m.Labels[k] = v
+		}
+	}
+	return m, nil
+}
+
+// Encode Returns the JSON encoding of the metadata
+func (b *Builder) Encode() ([]byte, error) {
+	m, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// Part Returns the metadata as the JSON part of a multipart upload
+func (b *Builder) Part() (source.Part, error) {
+	data, err := b.Encode()
+	if err != nil {
+		return source.Part{}, err
+	}
+	return NewPart(data), nil
+}
+
+// NewPart Returns encoded metadata as the JSON part of a multipart upload
+func NewPart(data []byte) source.Part {
+	return source.Part{
+		Name:     PartName,
+		Type:     "application/json",
+		Contents: bytes.NewReader(data),
+	}
+}
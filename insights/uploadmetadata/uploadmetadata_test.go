@@ -0,0 +1,102 @@
+package uploadmetadata
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+
+	"github.com/redhatinsights/insights-ingress-http-client/insights/insightsclient"
+	"github.com/redhatinsights/insights-ingress-http-client/insights/requestdecorator"
+)
+
+type clusterVersion struct {
+	cv  *configv1.ClusterVersion
+	err error
+}
+
+func (c clusterVersion) GetClusterVersion() (*configv1.ClusterVersion, error) {
+	return c.cv, c.err
+}
+
+func TestPart(tt *testing.T) {
+	config := requestdecorator.BasicRequestConfig{OperatorName: "insights-operator", OperatorCommit: "abc123", ClusterID: "config-id"}
+	version := func(id, desired string) *configv1.ClusterVersion {
+		cv := &configv1.ClusterVersion{}
+		cv.Spec.ClusterID = configv1.ClusterID(id)
+		cv.Status.Desired.Version = desired
+		return cv
+	}
+	testCases := []struct {
+		Name    string
+		Cluster ClusterVersionGetter
+		// Labels are set in order, an empty value removes the label
+		Labels   [][2]string
+		Expected Metadata
+		Err      error
+	}{
+		{
+			Name:     "Without cluster version",
+			Expected: Metadata{ClusterID: "config-id", OperatorName: "insights-operator", OperatorCommit: "abc123"},
+		},
+		{
+			Name:     "Cluster ID of the cluster version",
+			Cluster:  clusterVersion{cv: version("cluster-id", "4.7.0")},
+			Expected: Metadata{ClusterID: "cluster-id", ClusterVersion: "4.7.0", OperatorName: "insights-operator", OperatorCommit: "abc123"},
+		},
+		{
+			Name:     "Cluster version without a cluster ID",
+			Cluster:  clusterVersion{cv: version("", "4.7.0")},
+			Expected: Metadata{ClusterID: "config-id", ClusterVersion: "4.7.0", OperatorName: "insights-operator", OperatorCommit: "abc123"},
+		},
+		{
+			Name:   "Labels",
+			Labels: [][2]string{{"environment", "staging"}, {"removed", "label"}, {"removed", ""}},
+			Expected: Metadata{ClusterID: "config-id", OperatorName: "insights-operator", OperatorCommit: "abc123",
+				Labels: map[string]string{"environment": "staging"}},
+		},
+		{
+			Name:    "Version not known yet",
+			Cluster: clusterVersion{cv: version("cluster-id", "")},
+			Err:     insightsclient.ErrWaitingForVersion,
+		},
+		{
+			Name:    "Version not available",
+			Cluster: clusterVersion{err: errors.New("not found")},
+			Err:     insightsclient.ErrObtainingForVersion,
+		},
+	}
+	for _, tcase := range testCases {
+		tc := tcase
+		tt.Run(tc.Name, func(t *testing.T) {
+			b := New(tc.Cluster, config)
+			for _, label := range tc.Labels {
+				b.SetLabel(label[0], label[1])
+			}
+			part, err := b.Part()
+			if tc.Err != nil {
+				if err != tc.Err {
+					t.Fatalf("expected %v, got %v", tc.Err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			if part.Name != PartName || part.Filename != "" || part.Type != "application/json" {
+				t.Fatalf("unexpected part %s %s %s", part.Name, part.Filename, part.Type)
+			}
+			data, _ := ioutil.ReadAll(part.Contents)
+			var m Metadata
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.Fatalf("unable to decode %s: %v", data, err)
+			}
+			if !reflect.DeepEqual(m, tc.Expected) {
+				t.Fatalf("unexpected metadata %s", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package templates
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// decodeStrict decodes a YAML document into obj, failing on fields obj does not have,
+// such as the fields of a document merged into the previous one by a missing separator
+func decodeStrict(doc []byte, obj interface{}) error {
+	data, err := yaml.ToJSON(doc)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(obj)
+}
+
+func TestClusterRoleTemplate(t *testing.T) {
+	f, err := os.Open("cluster_role.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the rules of the roles by "<kind> <namespace>/<name>", cluster roles have no namespace
+	roles := map[string][]rbacv1.PolicyRule{}
+	var bindings []binding
+	var kinds []string
+	reader := yaml.NewYAMLReader(bufio.NewReader(f))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		var meta struct {
+			Kind string `json:"kind"`
+		}
+		if err := yaml.Unmarshal(doc, &meta); err != nil {
+			t.Fatalf("unable to decode document %d: %v", len(kinds), err)
+		}
+		kinds = append(kinds, meta.Kind)
+		switch meta.Kind {
+		case "Role":
+			role := &rbacv1.Role{}
+			if err := decodeStrict(doc, role); err != nil {
+				t.Fatalf("unable to decode document %d as a Role: %v", len(kinds), err)
+			}
+			for _, rule := range role.Rules {
+				for _, resource := range rule.Resources {
+					if clusterScoped[resource] {
+						t.Errorf("role %s/%s grants the cluster scoped %s", role.Namespace, role.Name, resource)
+					}
+				}
+			}
+			roles[roleKey("Role", role.Namespace, role.Name)] = role.Rules
+		case "ClusterRole":
+			role := &rbacv1.ClusterRole{}
+			if err := decodeStrict(doc, role); err != nil {
+				t.Fatalf("unable to decode document %d as a ClusterRole: %v", len(kinds), err)
+			}
+			roles[roleKey("ClusterRole", "", role.Name)] = role.Rules
+		case "RoleBinding":
+			b := &rbacv1.RoleBinding{}
+			if err := decodeStrict(doc, b); err != nil {
+				t.Fatalf("unable to decode document %d as a RoleBinding: %v", len(kinds), err)
+			}
+			bindings = append(bindings, binding{ObjectMeta: b.ObjectMeta, RoleRef: b.RoleRef, Subjects: b.Subjects})
+		case "ClusterRoleBinding":
+			b := &rbacv1.ClusterRoleBinding{}
+			if err := decodeStrict(doc, b); err != nil {
+				t.Fatalf("unable to decode document %d as a ClusterRoleBinding: %v", len(kinds), err)
+			}
+			bindings = append(bindings, binding{ObjectMeta: b.ObjectMeta, RoleRef: b.RoleRef, Subjects: b.Subjects})
+		default:
+			t.Fatalf("unexpected kind %q of document %d", meta.Kind, len(kinds))
+		}
+	}
+
+	if len(roles) != len(bindings) {
+		t.Fatalf("expected a binding for each role, got kinds %v", kinds)
+	}
+	for _, b := range bindings {
+		// a role binding may only refer to a role of its namespace
+		rules, ok := roles[roleKey(b.RoleRef.Kind, b.Namespace, b.RoleRef.Name)]
+		if !ok {
+			t.Errorf("binding %s/%s refers to the unknown %s %s", b.Namespace, b.Name, b.RoleRef.Kind, b.RoleRef.Name)
+			continue
+		}
+		if len(rules) == 0 {
+			t.Errorf("%s %s has no rules", b.RoleRef.Kind, b.RoleRef.Name)
+		}
+		if len(b.Subjects) != 1 || b.Subjects[0].Name != "OPERATOR_SERVICE_ACCOUNT" {
+			t.Errorf("binding %s/%s does not bind the operator service account: %v", b.Namespace, b.Name, b.Subjects)
+		}
+	}
+	for _, key := range []string{
+		roleKey("Role", "openshift-config", "OPERATOR_PREFIX-openshift-config-collector"),
+		roleKey("Role", "openshift-config-managed", "OPERATOR_PREFIX-openshift-config-managed-entitlement"),
+		roleKey("ClusterRole", "", "OPERATOR_PREFIX-cluster-proxy-reader"),
+	} {
+		if _, ok := roles[key]; !ok {
+			t.Errorf("%s is missing, got kinds %v", key, kinds)
+		}
+	}
+}
+
+// clusterScoped the resources that a namespaced Role cannot grant
+var clusterScoped = map[string]bool{
+	"proxies": true,
+}
+
+// binding the fields shared by RoleBinding and ClusterRoleBinding
+type binding struct {
+	metav1.ObjectMeta
+	RoleRef  rbacv1.RoleRef
+	Subjects []rbacv1.Subject
+}
+
+func roleKey(kind, namespace, name string) string {
+	return kind + " " + namespace + "/" + name
+}